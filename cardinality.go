@@ -0,0 +1,158 @@
+package probesdk
+
+import (
+	"context"
+	"encoding/json"
+	"go.opentelemetry.io/otel/attribute"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// DEFAULT_CARDINALITY_LIMIT 每个指标默认允许的属性组合数
+const DEFAULT_CARDINALITY_LIMIT = 2000
+
+// CARDINALITY_LIMIT_ENV 覆盖 Metrics 注册表的默认属性组合上限
+const CARDINALITY_LIMIT_ENV = "PROBESDK_METRIC_CARDINALITY_LIMIT"
+
+// CARDINALITY_DEBUG_PATH 调试服务上列出各指标属性组合的路径
+const CARDINALITY_DEBUG_PATH = "/debug/probesdk/cardinality"
+
+// 超出上限的记录统一记入带此属性的溢出序列，与 OpenTelemetry SDK 的约定一致
+var OverflowAttribute = attribute.Bool("otel.metric.overflow", true)
+
+var overflowSet = attribute.NewSet(OverflowAttribute)
+
+// 记录每个属性组合的记录次数，达到上限后新的组合记入溢出序列。
+// 已登记的组合只取读锁，计数用原子操作，热路径上不会互相阻塞
+type cardinalityLimiter struct {
+	name       string
+	limit      int
+	mu         sync.RWMutex
+	series     map[attribute.Distinct]*seriesCount
+	overflowed atomic.Int64
+}
+
+type seriesCount struct {
+	set   attribute.Set
+	count atomic.Int64
+}
+
+func newCardinalityLimiter(name string, limit int) *cardinalityLimiter {
+	return &cardinalityLimiter{name: name, limit: limit, series: make(map[attribute.Distinct]*seriesCount)}
+}
+
+func (l *cardinalityLimiter) admit(set attribute.Set) attribute.Set {
+	if l == nil {
+		return set
+	}
+	key := set.Equivalent()
+	l.mu.RLock()
+	s, ok := l.series[key]
+	full := len(l.series) >= l.limit
+	l.mu.RUnlock()
+	if ok {
+		s.count.Add(1)
+		return set
+	}
+	if !full {
+		l.mu.Lock()
+		// 拿写锁期间可能已被其他 goroutine 登记
+		s, ok = l.series[key]
+		if !ok && len(l.series) < l.limit {
+			s = &seriesCount{set: set}
+			l.series[key] = s
+			ok = true
+		}
+		l.mu.Unlock()
+		if ok {
+			s.count.Add(1)
+			return set
+		}
+	}
+	l.overflowed.Add(1)
+	MetricOverflow.Add(context.Background(), 1, attribute.String("instrument", l.name))
+	return overflowSet
+}
+
+// AttributeSetCount 一个属性组合及其记录次数
+type AttributeSetCount struct {
+	Attributes map[string]string `json:"attributes"`
+	Count      int64             `json:"count"`
+}
+
+// CardinalityReport 单个指标的属性组合统计
+type CardinalityReport struct {
+	Limit      int                 `json:"limit"`
+	Series     int                 `json:"series"`
+	Overflowed int64               `json:"overflowed"`
+	Top        []AttributeSetCount `json:"top"`
+}
+
+func (l *cardinalityLimiter) report(n int) CardinalityReport {
+	type snapshot struct {
+		set   attribute.Set
+		count int64
+	}
+	l.mu.RLock()
+	all := make([]snapshot, 0, len(l.series))
+	for _, s := range l.series {
+		all = append(all, snapshot{set: s.set, count: s.count.Load()})
+	}
+	l.mu.RUnlock()
+	series := len(all)
+	sort.Slice(all, func(i, j int) bool { return all[i].count > all[j].count })
+	if len(all) > n {
+		all = all[:n]
+	}
+	top := make([]AttributeSetCount, len(all))
+	for i, s := range all {
+		attrs := make(map[string]string, s.set.Len())
+		for _, kv := range s.set.ToSlice() {
+			attrs[string(kv.Key)] = kv.Value.Emit()
+		}
+		top[i] = AttributeSetCount{Attributes: attrs, Count: s.count}
+	}
+	return CardinalityReport{Limit: l.limit, Series: series, Overflowed: l.overflowed.Load(), Top: top}
+}
+
+// SetCardinalityLimit 设置之后注册的指标默认允许的属性组合数，负数表示不限制
+func (r *MetricRegistry) SetCardinalityLimit(limit int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cardinalityLimit = limit
+}
+
+// Cardinality 返回各指标记录次数最多的 n 个属性组合
+func (r *MetricRegistry) Cardinality(n int) map[string]CardinalityReport {
+	r.mu.Lock()
+	limiters := make(map[string]*cardinalityLimiter, len(r.limiters))
+	for name, l := range r.limiters {
+		limiters[name] = l
+	}
+	r.mu.Unlock()
+
+	reports := make(map[string]CardinalityReport, len(limiters))
+	for name, l := range limiters {
+		reports[name] = l.report(n)
+	}
+	return reports
+}
+
+// CardinalityHandler 以 JSON 输出全局 Metrics 的属性组合统计，?top=N 指定每个指标列出的组合数，默认 10
+func CardinalityHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if Metrics == nil {
+			http.Error(w, "metrics not initialized", http.StatusServiceUnavailable)
+			return
+		}
+		n := 10
+		if v, err := strconv.Atoi(r.URL.Query().Get("top")); err == nil && v > 0 {
+			n = v
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Metrics.Cardinality(n))
+	})
+}
@@ -0,0 +1,105 @@
+package probesdk
+
+import (
+	"context"
+	"encoding/json"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestCardinalityLimit(t *testing.T) {
+	reader := newMetricReader(t)
+	c, err := Metrics.Counter(InstrumentSpec{Name: "orders.by_user", CardinalityLimit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	c.Add(ctx, 1, attribute.String("user.id", "1"))
+	c.Add(ctx, 1, attribute.String("user.id", "1"))
+	for i := 2; i <= 5; i++ {
+		c.Add(ctx, 1, attribute.String("user.id", strconv.Itoa(i)))
+	}
+
+	dps := collectMetric(t, reader, "orders.by_user").Data.(metricdata.Sum[int64]).DataPoints
+	if len(dps) != 3 {
+		t.Fatalf("expect 2 series plus overflow, got %d", len(dps))
+	}
+	for _, dp := range dps {
+		if dp.Attributes.HasValue(OverflowAttribute.Key) && dp.Value != 3 {
+			t.Errorf("expect 3 recordings in overflow series, got %d", dp.Value)
+		}
+	}
+
+	overflow := collectMetric(t, reader, "probesdk.metric.overflow").Data.(metricdata.Sum[int64]).DataPoints[0]
+	if v, _ := overflow.Attributes.Value("instrument"); v.AsString() != "orders.by_user" || overflow.Value != 3 {
+		t.Errorf("expect 3 overflowed recordings for orders.by_user, got %d %v", overflow.Value, overflow.Attributes.ToSlice())
+	}
+
+	report := Metrics.Cardinality(1)["orders.by_user"]
+	if report.Limit != 2 || report.Series != 2 || report.Overflowed != 3 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if len(report.Top) != 1 || report.Top[0].Attributes["user.id"] != "1" || report.Top[0].Count != 2 {
+		t.Errorf("expect user 1 as top series, got %+v", report.Top)
+	}
+}
+
+func TestCardinalityAdmitConcurrent(t *testing.T) {
+	l := newCardinalityLimiter("concurrent", 4)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				l.admit(attribute.NewSet(attribute.Int("i", i%8)))
+			}
+		}()
+	}
+	wg.Wait()
+
+	report := l.report(10)
+	var admitted int64
+	for _, s := range report.Top {
+		admitted += s.Count
+	}
+	if report.Series != 4 || admitted+report.Overflowed != 8000 {
+		t.Errorf("expect 4 series and 8000 recordings, got %+v", report)
+	}
+}
+
+func TestCardinalityUnlimited(t *testing.T) {
+	r, reader := newTestRegistry()
+	r.SetCardinalityLimit(-1)
+	c, _ := r.Counter(InstrumentSpec{Name: "requests"})
+	for i := 0; i < 10; i++ {
+		c.Add(context.Background(), 1, attribute.Int("i", i))
+	}
+	if n := len(collectMetric(t, reader, "requests").Data.(metricdata.Sum[int64]).DataPoints); n != 10 {
+		t.Errorf("expect 10 series, got %d", n)
+	}
+	if _, ok := r.Cardinality(10)["requests"]; ok {
+		t.Errorf("expect no limiter for unlimited instrument")
+	}
+}
+
+func TestCardinalityHandler(t *testing.T) {
+	newMetricReader(t)
+	RequestCount.Add(context.Background(), 1, attribute.String("http.route", "/a"))
+
+	rec := httptest.NewRecorder()
+	CardinalityHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, CARDINALITY_DEBUG_PATH+"?top=5", nil))
+	var reports map[string]CardinalityReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &reports); err != nil {
+		t.Fatal(err)
+	}
+	report := reports["probesdk.http.server.requests"]
+	if report.Limit != DEFAULT_CARDINALITY_LIMIT || len(report.Top) != 1 || report.Top[0].Attributes["http.route"] != "/a" {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
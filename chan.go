@@ -0,0 +1,144 @@
+package probesdk
+
+import (
+	"context"
+	"errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 记在 Chan.Recv 压入的 stackFrame 上，下一次 Recv 或 Done 时弹出该条目。link 模式下出栈时结束 span
+type chanRecvMode int8
+
+const (
+	chanRecvNone chanRecvMode = iota
+	chanRecvParent
+	chanRecvLinked
+)
+
+// ErrChanClosed 由 RecvContext 在 Chan 关闭后返回
+var ErrChanClosed = errors.New("probesdk: chan closed")
+
+type chanItem[T any] struct {
+	value T
+	span  trace.Span
+}
+
+// 发送方的 span，ctx 中没有时取当前 goroutine trace 栈顶的 span
+func senderSpan(ctx context.Context) trace.Span {
+	if span := trace.SpanFromContext(ctx); span.SpanContext().IsValid() {
+		return span
+	}
+	return ActiveSpan()
+}
+
+// Chan 在 goroutine 之间传递值的同时传递发送方的 span。接收方在 Recv 后把发送方的 span 压入自己的 trace 栈，
+// 直到下一次 Recv 或调用 Done。spanName 为空时直接以发送方的 span 为父 span，
+// 否则为每个值开启一个名为 spanName 的新根 span，发送方的 span 作为 link
+type Chan[T any] struct {
+	ch       chan chanItem[T]
+	spanName string
+}
+
+// NewChan 创建容量为 size 的 Chan，接收方以发送方的 span 为父 span
+func NewChan[T any](size int) *Chan[T] {
+	return &Chan[T]{ch: make(chan chanItem[T], size)}
+}
+
+// NewLinkedChan 创建容量为 size 的 Chan，接收方为每个值开启名为 spanName 的根 span 并 link 到发送方的 span，
+// 适合一个消费者处理来自多个 trace 的工作
+func NewLinkedChan[T any](size int, spanName string) *Chan[T] {
+	return &Chan[T]{ch: make(chan chanItem[T], size), spanName: spanName}
+}
+
+// Send 发送 v，ctx 中没有 span 时使用当前 goroutine trace 栈顶的 span
+func (c *Chan[T]) Send(ctx context.Context, v T) {
+	c.ch <- chanItem[T]{value: v, span: senderSpan(ctx)}
+}
+
+// SendContext 同 Send，ctx 结束时放弃发送并返回 ctx.Err()
+func (c *Chan[T]) SendContext(ctx context.Context, v T) error {
+	select {
+	case c.ch <- chanItem[T]{value: v, span: senderSpan(ctx)}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Recv 接收一个值并把发送方的 span 压入当前 goroutine 的 trace 栈，返回的 ctx 携带该 span。
+// Chan 关闭后返回 ok 为 false，此时上一个值的 span 已弹出
+func (c *Chan[T]) Recv() (ctx context.Context, v T, ok bool) {
+	c.Done()
+	item, ok := <-c.ch
+	if !ok {
+		return context.Background(), v, false
+	}
+	return c.push(item), item.value, true
+}
+
+// RecvContext 同 Recv，ctx 结束时返回 ctx.Err()
+func (c *Chan[T]) RecvContext(ctx context.Context) (context.Context, T, error) {
+	c.Done()
+	var v T
+	select {
+	case item, ok := <-c.ch:
+		if !ok {
+			return ctx, v, ErrChanClosed
+		}
+		return c.push(item), item.value, nil
+	case <-ctx.Done():
+		return ctx, v, ctx.Err()
+	}
+}
+
+// Done 弹出当前 goroutine 最近一次 Recv 压入的 span，处理完一个值后调用；link 模式下同时结束该 span。
+// 只根据当前 goroutine 的 label 工作，对任意 Chan 调用效果相同
+func (c *Chan[T]) Done() {
+	data := peekProfLabel()
+	for depth := TraceStackDepth() - 1; depth >= 0; depth-- {
+		f := stackFrameAt(data, depth)
+		if f == nil || f.recv == chanRecvNone {
+			continue
+		}
+		popSpanEntry(depth)
+		// 父 span 模式下压入的是发送方的 span，由发送方结束
+		if f.recv == chanRecvLinked {
+			recordWallClock(f.span)
+			f.span.End()
+		}
+		return
+	}
+}
+
+// Close 关闭 Chan，接收方取完剩余的值后 Recv 返回 false
+func (c *Chan[T]) Close() {
+	close(c.ch)
+}
+
+// Len 返回缓冲中的值数
+func (c *Chan[T]) Len() int {
+	return len(c.ch)
+}
+
+func (c *Chan[T]) push(item chanItem[T]) context.Context {
+	var span trace.Span
+	if c.spanName == "" {
+		if !item.span.SpanContext().IsValid() {
+			return context.Background()
+		}
+		span = item.span
+	} else {
+		opts := []trace.SpanStartOption{trace.WithNewRoot()}
+		if sc := item.span.SpanContext(); sc.IsValid() {
+			opts = append(opts, trace.WithLinks(trace.Link{SpanContext: sc}))
+		}
+		_, span = otel.Tracer(TracerName).Start(context.Background(), c.spanName, opts...)
+	}
+	f := &stackFrame{recv: chanRecvParent}
+	if c.spanName != "" {
+		f.recv = chanRecvLinked
+	}
+	pushSpan(span, f)
+	return trace.ContextWithSpan(context.Background(), span)
+}
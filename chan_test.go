@@ -0,0 +1,152 @@
+package probesdk
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestChanParent(t *testing.T) {
+	sr := newSpanRecorder(t)
+	c := NewChan[int](1)
+
+	_, sender := StartSpan(context.Background(), "sender")
+	defer EndSpan(nil)
+	c.Send(context.Background(), 42)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ctx, v, ok := c.Recv()
+		if !ok || v != 42 {
+			t.Errorf("expect 42, got %d %v", v, ok)
+		}
+		if ActiveSpan() != sender {
+			t.Errorf("expect sender span on receiver stack, got %v", ActiveSpan().SpanContext())
+		}
+		if ctx == nil {
+			t.Errorf("expect ctx")
+		}
+		WithSpan("work", func(ctx context.Context) error { return nil })
+		depth := TraceStackDepth()
+		c.Done()
+		if TraceStackDepth() != depth-1 {
+			t.Errorf("expect sender span popped by Done")
+		}
+		c.Done()
+	}()
+	<-done
+
+	if findSpan(t, sr, "work").Parent().SpanID() != sender.SpanContext().SpanID() {
+		t.Errorf("expect work span child of sender span")
+	}
+	if !ActiveSpan().IsRecording() {
+		t.Errorf("expect sender span still active on sender goroutine")
+	}
+}
+
+func TestLinkedChan(t *testing.T) {
+	sr := newSpanRecorder(t)
+	c := NewLinkedChan[string](2, "consume")
+
+	var senders []string
+	for _, name := range []string{"a", "b"} {
+		func() {
+			ctx, span := StartSpan(context.Background(), name)
+			defer EndSpan(nil)
+			senders = append(senders, span.SpanContext().SpanID().String())
+			c.Send(ctx, name)
+		}()
+	}
+	c.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		depth := TraceStackDepth()
+		for {
+			_, v, ok := c.Recv()
+			if !ok {
+				break
+			}
+			if TraceStackDepth() != depth+1 {
+				t.Errorf("expect one entry pushed for %s", v)
+			}
+		}
+		if TraceStackDepth() != depth {
+			t.Errorf("expect stack restored after close")
+		}
+	}()
+	<-done
+
+	var consumed int
+	for _, s := range sr.Ended() {
+		if s.Name() != "consume" {
+			continue
+		}
+		if s.Parent().IsValid() || len(s.Links()) != 1 || s.Links()[0].SpanContext.SpanID().String() != senders[consumed] {
+			t.Errorf("expect root span linked to sender %s, got parent %v links %v", senders[consumed], s.Parent(), s.Links())
+		}
+		consumed++
+	}
+	if consumed != 2 {
+		t.Errorf("expect 2 consume spans ended, got %d", consumed)
+	}
+}
+
+func TestChanContext(t *testing.T) {
+	c := NewChan[int](0)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.SendContext(ctx, 1); err != context.DeadlineExceeded {
+		t.Errorf("expect deadline exceeded, got %v", err)
+	}
+	if _, _, err := c.RecvContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expect deadline exceeded, got %v", err)
+	}
+	c.Close()
+	if _, _, err := c.RecvContext(context.Background()); err != ErrChanClosed {
+		t.Errorf("expect ErrChanClosed, got %v", err)
+	}
+}
+
+// 接收方反复压栈、出栈发送方的 span 时，发送方 goroutine 上始终能找回它
+func TestChanActiveSpanRace(t *testing.T) {
+	newSpanRecorder(t)
+	c := NewChan[int](16)
+	_, sender := StartSpan(context.Background(), "sender")
+	defer EndSpan(nil)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, ok := c.Recv(); !ok {
+				return
+			}
+			if ActiveSpan() != sender {
+				t.Errorf("expect sender span active on receiver")
+			}
+			c.Done()
+		}
+	}()
+	go func() {
+		defer close(stop)
+		for i := 0; i < 1000; i++ {
+			c.Send(context.Background(), i)
+		}
+		c.Close()
+	}()
+	for {
+		select {
+		case <-stop:
+			<-done
+			return
+		default:
+		}
+		if ActiveSpan() != sender {
+			t.Fatalf("expect sender span active while receiver pops it")
+		}
+	}
+}
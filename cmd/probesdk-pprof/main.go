@@ -0,0 +1,155 @@
+// probesdk-pprof 将 profile 中 probesdk 写入的 trace 栈编码标签改写为可读的 trace_id/span_id 标签，
+// 可按 trace 过滤样本，或输出每个 trace 的热点函数。
+//
+//	probesdk-pprof [-o out.pb.gz] [-trace id] [-top n] profile.pb.gz
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/gongyuan167/probesdk/labels"
+	"github.com/google/pprof/profile"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "probesdk-pprof:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("probesdk-pprof", flag.ContinueOnError)
+	output := fs.String("o", "", "输出文件，默认为 <input>.decoded.pb.gz")
+	traceID := fs.String("trace", "", "只保留栈顶 span 属于该 trace 的样本")
+	top := fs.Int("top", 0, "不输出 profile，改为打印每个 trace 的前 n 个热点函数")
+	sampleIndex := fs.Int("sample_index", -1, "汇总使用的样本值下标，默认为最后一个")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: probesdk-pprof [-o out.pb.gz] [-trace id] [-top n] profile.pb.gz")
+	}
+	input := fs.Arg(0)
+
+	f, err := os.Open(input)
+	if err != nil {
+		return err
+	}
+	p, err := profile.Parse(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	labels.DecodeProfileLabels(p)
+	if *traceID != "" {
+		filterTrace(p, *traceID)
+	}
+
+	if *top > 0 {
+		idx := *sampleIndex
+		if idx < 0 {
+			idx = len(p.SampleType) - 1
+		}
+		if idx >= len(p.SampleType) {
+			return fmt.Errorf("sample_index %d out of range", idx)
+		}
+		return writeSummary(stdout, p, idx, *top)
+	}
+
+	if *output == "" {
+		*output = strings.TrimSuffix(strings.TrimSuffix(input, ".gz"), ".pb") + ".decoded.pb.gz"
+	}
+	out, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := p.Compact().Write(out); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func filterTrace(p *profile.Profile, traceID string) {
+	samples := p.Sample[:0]
+	for _, s := range p.Sample {
+		if ids := s.Label[labels.ProfTraceIDLabel]; len(ids) > 0 && ids[0] == traceID {
+			samples = append(samples, s)
+		}
+	}
+	p.Sample = samples
+}
+
+type funcValue struct {
+	name  string
+	value int64
+}
+
+// 按 trace 汇总叶子函数的 flat 值，trace 按总值从大到小输出
+func writeSummary(w io.Writer, p *profile.Profile, idx, n int) error {
+	totals := map[string]int64{}
+	flat := map[string]map[string]int64{}
+	for _, s := range p.Sample {
+		traceID := "untraced"
+		if ids := s.Label[labels.ProfTraceIDLabel]; len(ids) > 0 {
+			traceID = ids[0]
+		}
+		v := s.Value[idx]
+		totals[traceID] += v
+		if flat[traceID] == nil {
+			flat[traceID] = map[string]int64{}
+		}
+		flat[traceID][leafFunction(s)] += v
+	}
+
+	traces := make([]string, 0, len(totals))
+	for id := range totals {
+		traces = append(traces, id)
+	}
+	sort.Slice(traces, func(i, j int) bool {
+		if totals[traces[i]] != totals[traces[j]] {
+			return totals[traces[i]] > totals[traces[j]]
+		}
+		return traces[i] < traces[j]
+	})
+
+	unit := p.SampleType[idx].Type + "/" + p.SampleType[idx].Unit
+	for _, id := range traces {
+		fmt.Fprintf(w, "trace %s: %d %s\n", id, totals[id], unit)
+		funcs := make([]funcValue, 0, len(flat[id]))
+		for name, v := range flat[id] {
+			funcs = append(funcs, funcValue{name, v})
+		}
+		sort.Slice(funcs, func(i, j int) bool {
+			if funcs[i].value != funcs[j].value {
+				return funcs[i].value > funcs[j].value
+			}
+			return funcs[i].name < funcs[j].name
+		})
+		if len(funcs) > n {
+			funcs = funcs[:n]
+		}
+		for _, fv := range funcs {
+			pct := 0.0
+			if totals[id] != 0 {
+				pct = float64(fv.value) * 100 / float64(totals[id])
+			}
+			fmt.Fprintf(w, "  %12d %6.2f%%  %s\n", fv.value, pct, fv.name)
+		}
+	}
+	return nil
+}
+
+// 样本最内层的函数，Location.Line[0] 为内联展开后最内层的调用
+func leafFunction(s *profile.Sample) string {
+	if len(s.Location) == 0 || len(s.Location[0].Line) == 0 || s.Location[0].Line[0].Function == nil {
+		return "<unknown>"
+	}
+	return s.Location[0].Line[0].Function.Name
+}
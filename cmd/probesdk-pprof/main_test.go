@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"github.com/gongyuan167/probesdk/labels"
+	"github.com/google/pprof/profile"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var (
+	traceA = trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36}
+	traceB = trace.TraceID{0x01}
+)
+
+func encoded(traceID trace.TraceID, spanIDs ...byte) map[string][]string {
+	l := map[string][]string{labels.GRTTraceContextLen: {strconv.Itoa(len(spanIDs))}}
+	for i, id := range spanIDs {
+		sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: trace.SpanID{id}, TraceFlags: trace.FlagsSampled})
+		l[labels.StackKey(i)] = []string{labels.EncodeTraceContext(sc)}
+	}
+	return l
+}
+
+func writeTestProfile(t *testing.T) string {
+	fnA := &profile.Function{ID: 1, Name: "main.parse"}
+	fnB := &profile.Function{ID: 2, Name: "main.render"}
+	locA := &profile.Location{ID: 1, Line: []profile.Line{{Function: fnA}}}
+	locB := &profile.Location{ID: 2, Line: []profile.Line{{Function: fnB}}}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}, {Type: "cpu", Unit: "nanoseconds"}},
+		Function:   []*profile.Function{fnA, fnB},
+		Location:   []*profile.Location{locA, locB},
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{locA}, Value: []int64{3, 30}, Label: encoded(traceA, 1, 2)},
+			{Location: []*profile.Location{locB}, Value: []int64{1, 10}, Label: encoded(traceA, 1)},
+			{Location: []*profile.Location{locB}, Value: []int64{5, 50}, Label: encoded(traceB, 9)},
+			{Location: []*profile.Location{locA}, Value: []int64{2, 20}},
+		},
+	}
+	name := filepath.Join(t.TempDir(), "cpu.pb.gz")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := p.Write(f); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestDecodeAndFilter(t *testing.T) {
+	input := writeTestProfile(t)
+	out := filepath.Join(t.TempDir(), "out.pb.gz")
+	if err := run([]string{"-o", out, "-trace", traceA.String(), input}, &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p, err := profile.Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Sample) != 2 {
+		t.Fatalf("expect 2 samples of trace A, got %d", len(p.Sample))
+	}
+	for _, s := range p.Sample {
+		if s.Label[labels.ProfTraceIDLabel][0] != traceA.String() {
+			t.Errorf("unexpected trace label %v", s.Label)
+		}
+		if _, ok := s.Label["0"]; ok {
+			t.Errorf("expect encoded labels removed, got %v", s.Label)
+		}
+	}
+	stacks := map[string]bool{}
+	for _, s := range p.Sample {
+		stacks[s.Label[labels.ProfTraceStackLabel][0]] = true
+	}
+	if !stacks["0100000000000000>0200000000000000"] {
+		t.Errorf("expect nested trace stack label, got %v", stacks)
+	}
+}
+
+func TestTopSummary(t *testing.T) {
+	input := writeTestProfile(t)
+	var out bytes.Buffer
+	if err := run([]string{"-top", "1", input}, &out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("expect 3 traces with 1 function each, got:\n%s", out.String())
+	}
+	if !strings.HasPrefix(lines[0], "trace "+traceB.String()+": 50 cpu/nanoseconds") || !strings.Contains(lines[1], "main.render") {
+		t.Errorf("expect trace B first with render on top, got:\n%s", out.String())
+	}
+	if !strings.HasPrefix(lines[2], "trace "+traceA.String()+": 40") || !strings.Contains(lines[3], "main.parse") {
+		t.Errorf("expect trace A second with parse on top, got:\n%s", out.String())
+	}
+}
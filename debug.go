@@ -0,0 +1,78 @@
+package probesdk
+
+import (
+	"context"
+	"errors"
+	"go.opentelemetry.io/otel"
+	"net"
+	"net/http"
+	"os"
+)
+
+// DEBUG_ADDR_ENV 设置后在该地址上提供 probe 的调试接口（属性组合统计、初始化状态），默认不开启。
+// 调试接口会输出原始属性值，只写端口（如 :6061）时监听回环地址
+const DEBUG_ADDR_ENV = "PROBESDK_DEBUG_ADDR"
+
+// DebugHandler 返回挂载了 CARDINALITY_DEBUG_PATH 和 HEALTH_DEBUG_PATH 的 handler，可以挂到业务自己的内部端口上
+func DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(CARDINALITY_DEBUG_PATH, CardinalityHandler())
+	mux.Handle(HEALTH_DEBUG_PATH, HealthHandler())
+	return mux
+}
+
+// DebugServer 在独立的地址上提供 DebugHandler，与 Prometheus 抓取端口分开
+type DebugServer struct {
+	server   *http.Server
+	listener net.Listener
+}
+
+// NewDebugServer 监听 addr 并提供调试接口，addr 没有 host 时使用 127.0.0.1
+func NewDebugServer(addr string) (*DebugServer, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if host == "" {
+		addr = net.JoinHostPort("127.0.0.1", port)
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	server := &http.Server{Handler: DebugHandler()}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			otel.Handle(err)
+		}
+	}()
+	return &DebugServer{server: server, listener: listener}, nil
+}
+
+// Addr 返回实际监听的地址
+func (s *DebugServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *DebugServer) Shutdown(ctx context.Context) error {
+	err := s.server.Shutdown(ctx)
+	s.listener.Close()
+	return err
+}
+
+// InitDebugServer 按 DEBUG_ADDR_ENV 启动调试服务，未设置时不做任何事
+func InitDebugServer(ctx context.Context) (func(), error) {
+	addr := os.Getenv(DEBUG_ADDR_ENV)
+	if addr == "" {
+		return func() {}, nil
+	}
+	s, err := NewDebugServer(addr)
+	if err != nil {
+		return func() {}, err
+	}
+	return func() {
+		if err := s.Shutdown(ctx); err != nil {
+			otel.Handle(err)
+		}
+	}, nil
+}
@@ -0,0 +1,41 @@
+package probesdk
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDebugServer(t *testing.T) {
+	s, err := NewDebugServer(":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown(context.Background())
+
+	// 只写端口时监听回环地址
+	if host, _, _ := net.SplitHostPort(s.Addr()); host != "127.0.0.1" {
+		t.Errorf("expect loopback address, got %s", s.Addr())
+	}
+	for _, path := range []string{CARDINALITY_DEBUG_PATH, HEALTH_DEBUG_PATH} {
+		resp, err := http.Get("http://" + s.Addr() + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+			t.Errorf("expect json from %s, got %d %s", path, resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+	}
+}
+
+func TestInitDebugServerDisabledByDefault(t *testing.T) {
+	t.Setenv(DEBUG_ADDR_ENV, "")
+	shutdown, err := InitDebugServer(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	shutdown()
+}
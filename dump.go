@@ -0,0 +1,213 @@
+package probesdk
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gongyuan167/probesdk/labels"
+	"github.com/google/pprof/profile"
+	"io"
+	"net/http"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GOROUTINE_DUMP_PATH 按 trace 分组的 goroutine dump，与 net/http/pprof 挂在同一前缀下
+const GOROUTINE_DUMP_PATH = "/debug/pprof/goroutines-by-trace"
+
+// SpanRef goroutine trace 栈中的一个条目
+type SpanRef struct {
+	TraceID string `json:"trace_id"`
+	SpanID  string `json:"span_id"`
+	Sampled bool   `json:"sampled"`
+	Remote  bool   `json:"remote,omitempty"`
+}
+
+// GoroutineGroup 调用栈和标签都相同的一组 goroutine
+type GoroutineGroup struct {
+	Count int64 `json:"count"`
+	// Spans 为 goroutine 的 trace 栈，从栈底到栈顶
+	Spans []SpanRef `json:"spans,omitempty"`
+	// Labels 为 trace 栈以外的 pprof 标签
+	Labels map[string]string `json:"labels,omitempty"`
+	Stack  []string          `json:"stack"`
+}
+
+// TraceGoroutines 栈顶 span 属于同一 trace 的 goroutine
+type TraceGoroutines struct {
+	TraceID    string           `json:"trace_id"`
+	Count      int64            `json:"count"`
+	Goroutines []GoroutineGroup `json:"goroutines"`
+}
+
+// GoroutineDump 按 trace 分组的 goroutine 快照
+type GoroutineDump struct {
+	Total    int64             `json:"total"`
+	Traces   []TraceGoroutines `json:"traces"`
+	Untraced []GoroutineGroup  `json:"untraced"`
+}
+
+// CollectGoroutines 读取带标签的 goroutine profile，解出每个 goroutine 的 trace 栈并按 trace ID 分组。
+// 压栈和出栈只换上新的 label map，不修改已装上的，导出与其他 goroutine 的压栈、出栈可以并发
+func CollectGoroutines() (*GoroutineDump, error) {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 0); err != nil {
+		return nil, err
+	}
+	p, err := profile.Parse(&buf)
+	if err != nil {
+		return nil, err
+	}
+
+	dump := &GoroutineDump{}
+	traces := map[string]*TraceGoroutines{}
+	for _, s := range p.Sample {
+		g := newGoroutineGroup(s)
+		dump.Total += g.Count
+		if len(g.Spans) == 0 {
+			dump.Untraced = append(dump.Untraced, g)
+			continue
+		}
+		traceID := g.Spans[len(g.Spans)-1].TraceID
+		tg, ok := traces[traceID]
+		if !ok {
+			tg = &TraceGoroutines{TraceID: traceID}
+			traces[traceID] = tg
+		}
+		tg.Count += g.Count
+		tg.Goroutines = append(tg.Goroutines, g)
+	}
+
+	for _, tg := range traces {
+		dump.Traces = append(dump.Traces, *tg)
+	}
+	sort.Slice(dump.Traces, func(i, j int) bool {
+		if dump.Traces[i].Count != dump.Traces[j].Count {
+			return dump.Traces[i].Count > dump.Traces[j].Count
+		}
+		return dump.Traces[i].TraceID < dump.Traces[j].TraceID
+	})
+	sort.SliceStable(dump.Untraced, func(i, j int) bool { return dump.Untraced[i].Count > dump.Untraced[j].Count })
+	return dump, nil
+}
+
+func newGoroutineGroup(s *profile.Sample) GoroutineGroup {
+	g := GoroutineGroup{Count: s.Value[0]}
+	size, _ := strconv.Atoi(first(s.Label[GRTTraceContextLen]))
+	for i := 0; i < size; i++ {
+		sc, err := ParseTraceContext(first(s.Label[getTargetKey(i)]))
+		if err != nil {
+			continue
+		}
+		g.Spans = append(g.Spans, SpanRef{
+			TraceID: sc.TraceID().String(),
+			SpanID:  sc.SpanID().String(),
+			Sampled: sc.IsSampled(),
+			Remote:  sc.IsRemote(),
+		})
+	}
+	for k, v := range s.Label {
+		if labels.IsTraceStackLabel(k) {
+			continue
+		}
+		if g.Labels == nil {
+			g.Labels = map[string]string{}
+		}
+		g.Labels[k] = first(v)
+	}
+	for _, loc := range s.Location {
+		for _, line := range loc.Line {
+			if line.Function == nil {
+				continue
+			}
+			g.Stack = append(g.Stack, fmt.Sprintf("%s (%s:%d)", line.Function.Name, line.Function.Filename, line.Line))
+		}
+	}
+	return g
+}
+
+// DumpGoroutines 以文本格式输出按 trace 分组的 goroutine dump
+func DumpGoroutines(w io.Writer) error {
+	dump, err := CollectGoroutines()
+	if err != nil {
+		return err
+	}
+	return dump.WriteText(w)
+}
+
+// DumpGoroutinesJSON 以 JSON 格式输出按 trace 分组的 goroutine dump
+func DumpGoroutinesJSON(w io.Writer) error {
+	dump, err := CollectGoroutines()
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(dump)
+}
+
+func (d *GoroutineDump) WriteText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "goroutines: %d, traces: %d\n", d.Total, len(d.Traces))
+	for _, tg := range d.Traces {
+		fmt.Fprintf(&b, "\ntrace %s: %d goroutine(s)\n", tg.TraceID, tg.Count)
+		for _, g := range tg.Goroutines {
+			writeGoroutineGroup(&b, g)
+		}
+	}
+	if len(d.Untraced) > 0 {
+		fmt.Fprintf(&b, "\nuntraced:\n")
+		for _, g := range d.Untraced {
+			writeGoroutineGroup(&b, g)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeGoroutineGroup(b *strings.Builder, g GoroutineGroup) {
+	fmt.Fprintf(b, "\n%d goroutine(s)", g.Count)
+	if len(g.Spans) > 0 {
+		ids := make([]string, len(g.Spans))
+		for i, s := range g.Spans {
+			ids[i] = s.SpanID
+		}
+		fmt.Fprintf(b, " spans: %s", strings.Join(ids, " > "))
+	}
+	if len(g.Labels) > 0 {
+		keys := make([]string, 0, len(g.Labels))
+		for k := range g.Labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		labels := make([]string, len(keys))
+		for i, k := range keys {
+			labels[i] = k + "=" + g.Labels[k]
+		}
+		fmt.Fprintf(b, " labels: {%s}", strings.Join(labels, ", "))
+	}
+	b.WriteString("\n")
+	for _, frame := range g.Stack {
+		fmt.Fprintf(b, "  %s\n", frame)
+	}
+}
+
+// GoroutineDumpHandler 输出 goroutine dump，?format=json 时输出 JSON，默认为文本
+func GoroutineDumpHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dump, err := CollectGoroutines()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Query().Get("format") == "json" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(dump)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		dump.WriteText(w)
+	})
+}
@@ -0,0 +1,116 @@
+package probesdk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// 启动一个压入 span 后阻塞的 goroutine，返回其 trace ID、span ID 和释放函数
+func startBlockedSpan(t *testing.T) (string, string, func()) {
+	ids := make(chan [2]string)
+	release := make(chan struct{})
+	go func() {
+		IsolateProfLabel()
+		_, span := StartSpan(context.Background(), "blocked")
+		var err error
+		defer EndSpan(&err)
+		ids <- [2]string{span.SpanContext().TraceID().String(), span.SpanContext().SpanID().String()}
+		<-release
+	}()
+	got := <-ids
+	return got[0], got[1], func() { close(release) }
+}
+
+func TestCollectGoroutines(t *testing.T) {
+	newSpanRecorder(t)
+	traceID, spanID, release := startBlockedSpan(t)
+	defer release()
+
+	dump, err := CollectGoroutines()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found *TraceGoroutines
+	for i := range dump.Traces {
+		if dump.Traces[i].TraceID == traceID {
+			found = &dump.Traces[i]
+		}
+	}
+	if found == nil {
+		t.Fatalf("expect trace %s in dump, got %+v", traceID, dump.Traces)
+	}
+	g := found.Goroutines[0]
+	if g.Spans[len(g.Spans)-1].SpanID != spanID {
+		t.Errorf("expect top span %s, got %+v", spanID, g.Spans)
+	}
+	if !strings.Contains(strings.Join(g.Stack, "\n"), "startBlockedSpan") {
+		t.Errorf("expect blocked goroutine stack, got %v", g.Stack)
+	}
+	if len(dump.Untraced) == 0 || dump.Total < 2 {
+		t.Errorf("expect untraced goroutines in dump, got %d total", dump.Total)
+	}
+
+	var buf bytes.Buffer
+	if err := DumpGoroutines(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "trace "+traceID) || !strings.Contains(buf.String(), spanID) {
+		t.Errorf("expect trace %s in text dump:\n%s", traceID, buf.String())
+	}
+}
+
+// 其他 goroutine 压栈和出栈时导出，go test -race 下不应有数据竞争
+func TestCollectGoroutinesConcurrentSpans(t *testing.T) {
+	newSpanRecorder(t)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				WithSpan("busy", func(ctx context.Context) error { return nil })
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		if _, err := CollectGoroutines(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	<-done
+}
+
+func TestGoroutineDumpHandler(t *testing.T) {
+	newSpanRecorder(t)
+	traceID, _, release := startBlockedSpan(t)
+	defer release()
+
+	srv := httptest.NewServer(GoroutineDumpHandler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + GOROUTINE_DUMP_PATH + "?format=json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var dump GoroutineDump
+	if err := json.NewDecoder(resp.Body).Decode(&dump); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, tg := range dump.Traces {
+		found = found || tg.TraceID == traceID
+	}
+	if !found {
+		t.Errorf("expect trace %s in JSON dump", traceID)
+	}
+}
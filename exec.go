@@ -0,0 +1,98 @@
+package probesdk
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// 跨进程传递 trace context 使用的环境变量，与 W3C 的 traceparent/tracestate/baggage 头对应
+const TRACEPARENT_ENV = "TRACEPARENT"
+const TRACESTATE_ENV = "TRACESTATE"
+const BAGGAGE_ENV = "BAGGAGE"
+
+// 环境变量只使用 W3C 格式，不受 OTEL_PROPAGATORS 影响
+var envPropagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// EnvCarrier 以环境变量承载 trace context，key 转为大写并把 - 替换为 _，如 traceparent 对应 TRACEPARENT
+type EnvCarrier map[string]string
+
+func envName(key string) string {
+	return strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+}
+
+func (c EnvCarrier) Get(key string) string {
+	return c[envName(key)]
+}
+
+func (c EnvCarrier) Set(key, value string) {
+	c[envName(key)] = value
+}
+
+func (c EnvCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Command 与 exec.CommandContext 相同，并把 ctx 中的 span 注入子进程的环境变量，
+// ctx 中没有 span 时使用当前 goroutine trace 栈顶的 span
+func Command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	injectEnv(ctx, cmd)
+	return cmd
+}
+
+// InjectEnv 把当前 goroutine trace 栈顶的 span 写入 cmd 的环境变量，需要在 cmd.Start 前调用。
+// cmd.Env 为 nil 时以当前进程的环境变量为基础
+func InjectEnv(cmd *exec.Cmd) {
+	injectEnv(context.Background(), cmd)
+}
+
+func injectEnv(ctx context.Context, cmd *exec.Cmd) {
+	carrier := EnvCarrier{}
+	envPropagator.Inject(EnsureSpanContext(ctx), carrier)
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	// 去掉从本进程继承的旧值，避免子进程挂到祖先进程的 span 下
+	filtered := make([]string, 0, len(env)+len(carrier))
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		switch name {
+		case TRACEPARENT_ENV, TRACESTATE_ENV, BAGGAGE_ENV:
+			continue
+		}
+		filtered = append(filtered, kv)
+	}
+	for k, v := range carrier {
+		filtered = append(filtered, k+"="+v)
+	}
+	cmd.Env = filtered
+}
+
+// ContextFromEnv 从当前进程的 TRACEPARENT/TRACESTATE/BAGGAGE 环境变量中解出远程 span 和 baggage
+func ContextFromEnv(ctx context.Context) context.Context {
+	carrier := EnvCarrier{}
+	for _, name := range []string{TRACEPARENT_ENV, TRACESTATE_ENV, BAGGAGE_ENV} {
+		if v, ok := os.LookupEnv(name); ok {
+			carrier[name] = v
+		}
+	}
+	return envPropagator.Extract(ctx, carrier)
+}
+
+// 由父进程启动时父进程的 span，init 中读取后不再修改。trace 栈为空时 EnsureSpanContext 以它为父 span，
+// 使本进程的根 span 成为它的子 span。不压入 main goroutine 的 trace 栈：之后创建的 goroutine 都继承 main 的 label map，
+// 在共享的 map 上并发压栈会相互破坏
+var envParent trace.SpanContext
+
+func loadEnvParent() {
+	envParent = trace.SpanContextFromContext(ContextFromEnv(context.Background()))
+}
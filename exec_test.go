@@ -0,0 +1,148 @@
+package probesdk
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func envValue(env []string, name string) (string, int) {
+	value, n := "", 0
+	for _, kv := range env {
+		if k, v, _ := strings.Cut(kv, "="); k == name {
+			value = v
+			n++
+		}
+	}
+	return value, n
+}
+
+func TestCommandInjectsEnv(t *testing.T) {
+	newSpanRecorder(t)
+	t.Setenv(TRACEPARENT_ENV, "00-11111111111111111111111111111111-2222222222222222-01")
+
+	member, _ := baggage.NewMember("tenant", "acme")
+	bag, _ := baggage.New(member)
+	ctx, span := StartSpan(baggage.ContextWithBaggage(context.Background(), bag), "orchestrate")
+	defer EndSpan(nil)
+
+	cmd := Command(ctx, "true")
+	sc := span.SpanContext()
+	tp, n := envValue(cmd.Env, TRACEPARENT_ENV)
+	if n != 1 || tp != "00-"+sc.TraceID().String()+"-"+sc.SpanID().String()+"-01" {
+		t.Errorf("expect inherited TRACEPARENT replaced by current span, got %q (%d)", tp, n)
+	}
+	if b, _ := envValue(cmd.Env, BAGGAGE_ENV); b != "tenant=acme" {
+		t.Errorf("expect baggage injected, got %q", b)
+	}
+	if _, n := envValue(cmd.Env, "PATH"); n != 1 {
+		t.Errorf("expect process environment kept")
+	}
+}
+
+func TestInjectEnvFromStack(t *testing.T) {
+	newSpanRecorder(t)
+	_, span := StartSpan(context.Background(), "batch")
+	defer EndSpan(nil)
+
+	cmd := Command(context.Background(), "true")
+	cmd.Env = []string{"A=1"}
+	InjectEnv(cmd)
+	tp, _ := envValue(cmd.Env, TRACEPARENT_ENV)
+	if !strings.Contains(tp, span.SpanContext().SpanID().String()) {
+		t.Errorf("expect span from goroutine stack injected, got %v", cmd.Env)
+	}
+	if a, _ := envValue(cmd.Env, "A"); a != "1" {
+		t.Errorf("expect explicit env kept, got %v", cmd.Env)
+	}
+}
+
+// 作为子进程运行时输出根 span 的父 span，并在继承 main label map 的 goroutine 上并发开启 span
+func TestExecHelperProcess(t *testing.T) {
+	if os.Getenv("PROBESDK_EXEC_HELPER") != "1" {
+		t.Skip("only runs as child process")
+	}
+	parent := trace.SpanContextFromContext(EnsureSpanContext(context.Background()))
+	if !parent.IsValid() {
+		os.Stdout.WriteString("no span\n")
+		return
+	}
+	os.Stdout.WriteString(parent.TraceID().String() + " " + parent.SpanID().String() + "\n")
+	if TraceStackDepth() != 0 {
+		os.Stdout.WriteString("main trace stack seeded\n")
+	}
+
+	var failed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				WithSpan("outer", func(ctx context.Context) error {
+					return WithSpan("inner", func(ctx context.Context) error {
+						if trace.SpanContextFromContext(ctx).TraceID() != parent.TraceID() || TraceStackDepth() != 2 {
+							failed.Add(1)
+						}
+						return nil
+					})
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	os.Stdout.WriteString("concurrent failures: " + strconv.FormatInt(failed.Load(), 10) + "\n")
+}
+
+func TestChildProcessInheritsParentSpan(t *testing.T) {
+	newSpanRecorder(t)
+	ctx, span := StartSpan(context.Background(), "spawn")
+	defer EndSpan(nil)
+
+	cmd := Command(ctx, os.Args[0], "-test.run=^TestExecHelperProcess$")
+	cmd.Env = append(cmd.Env, "PROBESDK_EXEC_HELPER=1")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%v:\n%s", err, out)
+	}
+	sc := span.SpanContext()
+	want := sc.TraceID().String() + " " + sc.SpanID().String()
+	if !strings.Contains(string(out), want) {
+		t.Errorf("expect child parented to %s, got:\n%s", want, out)
+	}
+	if strings.Contains(string(out), "seeded") || !strings.Contains(string(out), "concurrent failures: 0\n") {
+		t.Errorf("expect concurrent spans in child to succeed without touching main labels, got:\n%s", out)
+	}
+}
+
+func TestEnsureSpanContextFallsBackToEnv(t *testing.T) {
+	prev := envParent
+	defer func() { envParent = prev }()
+	t.Setenv(TRACEPARENT_ENV, "00-11111111111111111111111111111111-2222222222222222-01")
+	loadEnvParent()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		IsolateProfLabel()
+		sc := trace.SpanContextFromContext(EnsureSpanContext(context.Background()))
+		if sc.SpanID().String() != "2222222222222222" || !sc.IsRemote() {
+			t.Errorf("expect remote parent from env on empty stack, got %v", sc)
+		}
+		// trace 栈上有 span 时优先使用栈顶
+		WithSpan("local", func(ctx context.Context) error {
+			local := trace.SpanContextFromContext(ctx)
+			if got := trace.SpanContextFromContext(EnsureSpanContext(context.Background())); got.SpanID() != local.SpanID() {
+				t.Errorf("expect stack top %s, got %s", local.SpanID(), got.SpanID())
+			}
+			return nil
+		})
+	}()
+	<-done
+}
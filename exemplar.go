@@ -0,0 +1,29 @@
+package probesdk
+
+import (
+	"fmt"
+	"go.opentelemetry.io/otel/sdk/metric/exemplar"
+	"os"
+	"strings"
+)
+
+// EXEMPLAR_FILTER_ENV 控制哪些记录会生成 exemplar：always、trace_based（默认）、off，
+// 也接受 SDK 的 always_on / always_off 写法
+const EXEMPLAR_FILTER_ENV = "OTEL_METRICS_EXEMPLAR_FILTER"
+
+// ExemplarFilter 按名称返回 exemplar 过滤器。trace_based 只为采样的 span 生成 exemplar
+func ExemplarFilter(name string) (exemplar.Filter, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "always", "always_on":
+		return exemplar.AlwaysOnFilter, nil
+	case "", "trace_based":
+		return exemplar.TraceBasedFilter, nil
+	case "off", "always_off":
+		return exemplar.AlwaysOffFilter, nil
+	}
+	return nil, fmt.Errorf("unknown exemplar filter %q", name)
+}
+
+func exemplarFilterFromEnv() (exemplar.Filter, error) {
+	return ExemplarFilter(os.Getenv(EXEMPLAR_FILTER_ENV))
+}
@@ -0,0 +1,108 @@
+package probesdk
+
+import (
+	"context"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newExemplarHistogram(t *testing.T, filter string) (*Histogram, *sdkmetric.ManualReader) {
+	f, err := ExemplarFilter(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader), sdkmetric.WithExemplarFilter(f))
+	h, err := NewMetricRegistry(provider.Meter("test")).Histogram(InstrumentSpec{Name: "job.duration", Unit: "s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h, reader
+}
+
+func histogramExemplars(t *testing.T, reader *sdkmetric.ManualReader, name string) []metricdata.Exemplar[float64] {
+	dps := collectMetric(t, reader, name).Data.(metricdata.Histogram[float64]).DataPoints
+	if len(dps) == 0 {
+		t.Fatalf("no data point for %s", name)
+	}
+	return dps[0].Exemplars
+}
+
+func TestExemplarFromGoroutineStack(t *testing.T) {
+	newSpanRecorder(t)
+	h, reader := newExemplarHistogram(t, "trace_based")
+
+	_, span := StartSpan(context.Background(), "job")
+	// ctx 中没有 span，exemplar 取自 goroutine 的 trace 栈
+	h.Record(context.Background(), 0.2)
+	var err error
+	EndSpan(&err)
+
+	exemplars := histogramExemplars(t, reader, "job.duration")
+	if len(exemplars) != 1 {
+		t.Fatalf("expect 1 exemplar, got %d", len(exemplars))
+	}
+	sc := span.SpanContext()
+	if trace.TraceID(exemplars[0].TraceID) != sc.TraceID() || trace.SpanID(exemplars[0].SpanID) != sc.SpanID() {
+		t.Errorf("expect exemplar for span %s, got %x/%x", sc.SpanID(), exemplars[0].TraceID, exemplars[0].SpanID)
+	}
+}
+
+func TestExemplarFromContext(t *testing.T) {
+	h, reader := newExemplarHistogram(t, "trace_based")
+	tp := sdktrace.NewTracerProvider()
+	ctx, span := tp.Tracer("test").Start(context.Background(), "op")
+	h.Record(ctx, 0.1)
+	span.End()
+
+	exemplars := histogramExemplars(t, reader, "job.duration")
+	if len(exemplars) != 1 || trace.SpanID(exemplars[0].SpanID) != span.SpanContext().SpanID() {
+		t.Errorf("expect exemplar for span from ctx, got %+v", exemplars)
+	}
+}
+
+func TestExemplarFilters(t *testing.T) {
+	h, reader := newExemplarHistogram(t, "off")
+	tp := sdktrace.NewTracerProvider()
+	ctx, span := tp.Tracer("test").Start(context.Background(), "op")
+	h.Record(ctx, 0.1)
+	span.End()
+	if n := len(histogramExemplars(t, reader, "job.duration")); n != 0 {
+		t.Errorf("expect no exemplar with off filter, got %d", n)
+	}
+
+	// 未采样或没有 span 时 trace_based 不生成 exemplar，always 仍然生成
+	h, reader = newExemplarHistogram(t, "trace_based")
+	h.Record(context.Background(), 0.1)
+	if n := len(histogramExemplars(t, reader, "job.duration")); n != 0 {
+		t.Errorf("expect no exemplar without span, got %d", n)
+	}
+	h, reader = newExemplarHistogram(t, "always")
+	h.Record(context.Background(), 0.1)
+	if n := len(histogramExemplars(t, reader, "job.duration")); n != 1 {
+		t.Errorf("expect exemplar with always filter, got %d", n)
+	}
+
+	if _, err := ExemplarFilter("sometimes"); err == nil {
+		t.Errorf("expect error for unknown filter")
+	}
+}
+
+func TestRequestDurationExemplar(t *testing.T) {
+	sr := newSpanRecorder(t)
+	reader := newMetricReader(t)
+
+	h := HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+
+	span := findSpan(t, sr, "GET")
+	exemplars := histogramExemplars(t, reader, "http.server.request.duration")
+	if len(exemplars) != 1 || trace.TraceID(exemplars[0].TraceID) != span.SpanContext().TraceID() {
+		t.Errorf("expect exemplar linking to the server span, got %+v", exemplars)
+	}
+}
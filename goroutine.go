@@ -13,6 +13,11 @@ type labelMap map[string]string
 //go:linkname Runtime_getProfLabel runtime/pprof.runtime_getProfLabel
 func Runtime_getProfLabel() unsafe.Pointer
 
+//go:linkname runtime_setProfLabel runtime/pprof.runtime_setProfLabel
+func runtime_setProfLabel(labels unsafe.Pointer)
+
+// GetProfLabel 返回当前 goroutine 的 label map，没有时创建一个空的。
+// 返回的 map 可能正被 profile 读取或被子 goroutine 共享，只能读
 func GetProfLabel() map[string]string {
 	ptr := Runtime_getProfLabel()
 	result := (*labelMap)(ptr)
@@ -23,3 +28,36 @@ func GetProfLabel() map[string]string {
 	}
 	return *result
 }
+
+// 只读地取当前 goroutine 的 label map，没有时返回 nil 而不创建。
+// 只读的调用不能给 goroutine 装上 map，否则之后由它创建的 goroutine 都会共享这个 map
+func peekProfLabel() map[string]string {
+	if result := (*labelMap)(Runtime_getProfLabel()); result != nil {
+		return *result
+	}
+	return nil
+}
+
+// 复制当前 goroutine 的 label map 用于修改，extra 为预计新增的 key 数
+func cloneProfLabel(extra int) map[string]string {
+	data := peekProfLabel()
+	clone := make(map[string]string, len(data)+extra)
+	for k, v := range data {
+		clone[k] = v
+	}
+	return clone
+}
+
+// 把 data 整体换为当前 goroutine 的 label map。已装上的 map 可能正被 goroutine/CPU profile 遍历，
+// 也可能被子 goroutine 继承，只能整体替换，装上之后不能再修改。返回装上的 map 指针
+func setProfLabel(data map[string]string) unsafe.Pointer {
+	m := labelMap(data)
+	runtime_setProfLabel(unsafe.Pointer(&m))
+	return unsafe.Pointer(&m)
+}
+
+// IsolateProfLabel 为当前 goroutine 复制一份独立的 label map。
+// trace 栈的压栈和出栈都会换上新的 map，不会修改与其他 goroutine 共享的 map，一般不需要再调用
+func IsolateProfLabel() {
+	setProfLabel(cloneProfLabel(0))
+}
@@ -0,0 +1,213 @@
+package probesdk
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metadataCarrier 让 gRPC metadata 实现 propagation.TextMapCarrier
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	values := metadata.MD(c).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// GRPCUnaryServerInterceptor 从 metadata 中提取上游 trace context，开启 SERVER span 并压入处理请求的 goroutine 的 trace 栈
+func GRPCUnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		ctx, span, depth := startGRPCServerSpan(ctx, info.FullMethod)
+		start := time.Now()
+		defer func() {
+			rec := recover()
+			popSpanEntry(depth)
+			endGRPCSpan(ctx, span, RPCServerDuration, start, info.FullMethod, err, rec)
+			if rec != nil {
+				panic(rec)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// GRPCStreamServerInterceptor 同 GRPCUnaryServerInterceptor，span 覆盖整个流的处理过程
+func GRPCStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		ctx, span, depth := startGRPCServerSpan(ss.Context(), info.FullMethod)
+		start := time.Now()
+		defer func() {
+			rec := recover()
+			popSpanEntry(depth)
+			endGRPCSpan(ctx, span, RPCServerDuration, start, info.FullMethod, err, rec)
+			if rec != nil {
+				panic(rec)
+			}
+		}()
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// GRPCUnaryClientInterceptor 开启 CLIENT span 并将 trace context 注入 metadata，
+// ctx 中没有 span 时以当前 goroutine trace 栈顶的 span 为父 span
+func GRPCUnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, span := startGRPCClientSpan(ctx, method)
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		endGRPCSpan(ctx, span, RPCClientDuration, start, method, err, nil)
+		return err
+	}
+}
+
+// GRPCStreamClientInterceptor 同 GRPCUnaryClientInterceptor，span 在流结束（RecvMsg 返回错误或 io.EOF）时结束
+func GRPCStreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, span := startGRPCClientSpan(ctx, method)
+		start := time.Now()
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			endGRPCSpan(ctx, span, RPCClientDuration, start, method, err, nil)
+			return nil, err
+		}
+		return &clientStream{
+			ClientStream: cs,
+			desc:         desc,
+			finish: func(err error) {
+				endGRPCSpan(ctx, span, RPCClientDuration, start, method, err, nil)
+			},
+		}, nil
+	}
+}
+
+func startGRPCServerSpan(ctx context.Context, fullMethod string) (context.Context, trace.Span, int) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx = otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md))
+	ctx, span := otel.Tracer(TracerName).Start(
+		ctx,
+		strings.TrimPrefix(fullMethod, "/"),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(rpcAttributes(fullMethod)...),
+	)
+	depth := TraceStackDepth()
+	OnSpanStart(span)
+	return ctx, span, depth
+}
+
+func startGRPCClientSpan(ctx context.Context, fullMethod string) (context.Context, trace.Span) {
+	ctx, span := otel.Tracer(TracerName).Start(
+		EnsureSpanContext(ctx),
+		strings.TrimPrefix(fullMethod, "/"),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(rpcAttributes(fullMethod)...),
+	)
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	otel.GetTextMapPropagator().Inject(ctx, metadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md), span
+}
+
+func endGRPCSpan(ctx context.Context, span trace.Span, duration *Histogram, start time.Time, fullMethod string, err error, rec interface{}) {
+	code := status.Code(err)
+	if rec != nil {
+		code = grpccodes.Internal
+		span.SetStatus(codes.Error, fmt.Sprintf("panic: %v", rec))
+	} else if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, status.Convert(err).Message())
+	}
+	statusAttr := semconv.RPCGRPCStatusCodeKey.Int(int(code))
+	span.SetAttributes(statusAttr)
+	recordWallClock(span)
+	span.End()
+
+	attrs := append(rpcAttributes(fullMethod), statusAttr)
+	duration.Record(ctx, float64(time.Since(start))/float64(time.Millisecond), attrs...)
+}
+
+// fullMethod 的格式为 /package.Service/Method
+func rpcAttributes(fullMethod string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{semconv.RPCSystemGRPC}
+	name := strings.TrimPrefix(fullMethod, "/")
+	if idx := strings.LastIndex(name, "/"); idx >= 0 {
+		attrs = append(attrs, semconv.RPCService(name[:idx]), semconv.RPCMethod(name[idx+1:]))
+	}
+	return attrs
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+type clientStream struct {
+	grpc.ClientStream
+	desc   *grpc.StreamDesc
+	finish func(err error)
+	once   sync.Once
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	switch {
+	case err == io.EOF:
+		s.once.Do(func() { s.finish(nil) })
+	case err != nil:
+		s.once.Do(func() { s.finish(err) })
+	case !s.desc.ServerStreams:
+		// 服务端只返回一条消息时，收到即结束
+		s.once.Do(func() { s.finish(nil) })
+	}
+	return err
+}
+
+func (s *clientStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	if err != nil && err != io.EOF {
+		s.once.Do(func() { s.finish(err) })
+	}
+	return err
+}
+
+func (s *clientStream) Header() (metadata.MD, error) {
+	md, err := s.ClientStream.Header()
+	if err != nil {
+		s.once.Do(func() { s.finish(err) })
+	}
+	return md, err
+}
@@ -0,0 +1,173 @@
+package probesdk
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"net"
+	"testing"
+)
+
+type healthServer struct {
+	healthpb.UnimplementedHealthServer
+	spanIDs chan trace.SpanID
+}
+
+func (s *healthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	s.spanIDs <- legacySpanID()
+	if req.Service == "missing" {
+		return nil, status.Error(grpccodes.NotFound, "unknown service")
+	}
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
+
+func (s *healthServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	s.spanIDs <- legacySpanID()
+	for i := 0; i < 2; i++ {
+		if err := stream.Send(&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newBufconnClient(t *testing.T) (healthpb.HealthClient, *healthServer) {
+	lis := bufconn.Listen(1 << 20)
+	hs := &healthServer{spanIDs: make(chan trace.SpanID, 1)}
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(GRPCUnaryServerInterceptor()),
+		grpc.StreamInterceptor(GRPCStreamServerInterceptor()),
+	)
+	healthpb.RegisterHealthServer(srv, hs)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(GRPCUnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(GRPCStreamClientInterceptor()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return healthpb.NewHealthClient(conn), hs
+}
+
+func TestGRPCUnaryInterceptors(t *testing.T) {
+	sr := newSpanRecorder(t)
+	reader := newMetricReader(t)
+	client, hs := newBufconnClient(t)
+
+	err := WithSpan("caller", func(_ context.Context) error {
+		_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handlerSpanID := <-hs.spanIDs
+
+	var clientSpan, serverSpan sdktrace.ReadOnlySpan
+	for _, s := range sr.Ended() {
+		switch s.SpanKind() {
+		case trace.SpanKindClient:
+			clientSpan = s
+		case trace.SpanKindServer:
+			serverSpan = s
+		}
+	}
+	if clientSpan == nil || serverSpan == nil {
+		t.Fatalf("expect client and server spans, got %d spans", len(sr.Ended()))
+	}
+	caller := findSpan(t, sr, "caller")
+	if clientSpan.Parent().SpanID() != caller.SpanContext().SpanID() {
+		t.Errorf("expect client span parented from goroutine stack")
+	}
+	if serverSpan.Parent().SpanID() != clientSpan.SpanContext().SpanID() || !serverSpan.Parent().IsRemote() {
+		t.Errorf("expect server span parented from metadata")
+	}
+	if handlerSpanID != serverSpan.SpanContext().SpanID() {
+		t.Errorf("expect server span on handler goroutine stack")
+	}
+	attrs := attribute.NewSet(serverSpan.Attributes()...)
+	if v, _ := attrs.Value(semconv.RPCServiceKey); v.AsString() != "grpc.health.v1.Health" {
+		t.Errorf("expect rpc.service attribute, got %v", v)
+	}
+	if v, _ := attrs.Value(semconv.RPCMethodKey); v.AsString() != "Check" {
+		t.Errorf("expect rpc.method attribute, got %v", v)
+	}
+
+	for _, name := range []string{"rpc.server.duration", "rpc.client.duration"} {
+		h := collectMetric(t, reader, name).Data.(metricdata.Histogram[float64])
+		if len(h.DataPoints) != 1 || h.DataPoints[0].Count != 1 {
+			t.Errorf("expect one recording in %s, got %+v", name, h.DataPoints)
+		}
+	}
+}
+
+func TestGRPCUnaryInterceptorsError(t *testing.T) {
+	sr := newSpanRecorder(t)
+	newMetricReader(t)
+	client, hs := newBufconnClient(t)
+
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "missing"})
+	if status.Code(err) != grpccodes.NotFound {
+		t.Fatalf("expect NotFound, got %v", err)
+	}
+	<-hs.spanIDs
+
+	for _, s := range sr.Ended() {
+		attrs := attribute.NewSet(s.Attributes()...)
+		if v, _ := attrs.Value(semconv.RPCGRPCStatusCodeKey); v.AsInt64() != int64(grpccodes.NotFound) {
+			t.Errorf("expect NotFound status code on %v span, got %v", s.SpanKind(), v)
+		}
+	}
+}
+
+func TestGRPCStreamInterceptors(t *testing.T) {
+	sr := newSpanRecorder(t)
+	newMetricReader(t)
+	client, hs := newBufconnClient(t)
+
+	stream, err := client.Watch(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := 0
+	for {
+		_, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		received++
+	}
+	handlerSpanID := <-hs.spanIDs
+
+	if received != 2 {
+		t.Errorf("expect 2 messages, got %d", received)
+	}
+	spans := sr.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expect client and server spans, got %d", len(spans))
+	}
+	for _, s := range spans {
+		if s.SpanKind() == trace.SpanKindServer && s.SpanContext().SpanID() != handlerSpanID {
+			t.Errorf("expect server span on handler goroutine stack")
+		}
+	}
+}
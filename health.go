@@ -0,0 +1,102 @@
+package probesdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// 初始化的各个信号
+const (
+	SignalResource = "resource"
+	SignalTrace    = "trace"
+	SignalMetric   = "metric"
+	SignalLog      = "log"
+	SignalProfile  = "profile"
+	SignalOffCPU   = "offcpu"
+)
+
+// HEALTH_DEBUG_PATH 调试服务上查询初始化状态的路径
+const HEALTH_DEBUG_PATH = "/debug/probesdk/health"
+
+// SignalHealth 单个信号的初始化结果，Error 不为空时该信号退化为 no-op
+type SignalHealth struct {
+	Signal string `json:"signal"`
+	OK     bool   `json:"ok"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HealthStatus 探针的初始化状态，任一信号失败时 Degraded 为 true
+type HealthStatus struct {
+	Degraded bool           `json:"degraded"`
+	Signals  []SignalHealth `json:"signals"`
+}
+
+var healthMu sync.Mutex
+var healthSignals = map[string]SignalHealth{}
+
+func setSignalHealth(signal string, err error) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	h := SignalHealth{Signal: signal, OK: err == nil}
+	if err != nil {
+		h.Error = err.Error()
+	}
+	healthSignals[signal] = h
+}
+
+// Health 返回探针当前的初始化状态
+func Health() HealthStatus {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	status := HealthStatus{Signals: make([]SignalHealth, 0, len(healthSignals))}
+	for _, h := range healthSignals {
+		status.Signals = append(status.Signals, h)
+		if !h.OK {
+			status.Degraded = true
+		}
+	}
+	sort.Slice(status.Signals, func(i, j int) bool { return status.Signals[i].Signal < status.Signals[j].Signal })
+	return status
+}
+
+// HealthHandler 以 JSON 输出 Health()，降级时返回 503
+func HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := Health()
+		w.Header().Set("Content-Type", "application/json")
+		if status.Degraded {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(status)
+	})
+}
+
+// 执行一个信号的初始化并记录结果，panic 也转换为错误，保证遥测配置问题不会让宿主进程退出
+func initSignal(signal string, fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+		setSignalHealth(signal, err)
+	}()
+	return fn()
+}
+
+// 有信号初始化失败时输出一条汇总的结构化告警
+func warnIfDegraded() {
+	status := Health()
+	if !status.Degraded {
+		return
+	}
+	attrs := make([]any, 0, len(status.Signals))
+	for _, h := range status.Signals {
+		if !h.OK {
+			attrs = append(attrs, slog.String(h.Signal, h.Error))
+		}
+	}
+	slog.Warn("probesdk: telemetry initialization failed, falling back to no-op providers", slog.Group("errors", attrs...))
+}
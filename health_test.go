@@ -0,0 +1,162 @@
+package probesdk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// 替换全局健康状态，测试结束后恢复
+func resetHealth(t *testing.T) {
+	healthMu.Lock()
+	prev := healthSignals
+	healthSignals = map[string]SignalHealth{}
+	healthMu.Unlock()
+	t.Cleanup(func() {
+		healthMu.Lock()
+		healthSignals = prev
+		healthMu.Unlock()
+	})
+}
+
+func TestInitSignalRecoversPanic(t *testing.T) {
+	resetHealth(t)
+
+	if err := initSignal(SignalTrace, func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := initSignal(SignalMetric, func() error { panic("bad exporter") }); err == nil {
+		t.Errorf("expect panic converted to error")
+	}
+	initSignal(SignalLog, func() error { return errors.New("no endpoint") })
+
+	status := Health()
+	if !status.Degraded || len(status.Signals) != 3 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	for _, h := range status.Signals {
+		if h.OK != (h.Signal == SignalTrace) {
+			t.Errorf("unexpected signal health %+v", h)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	HealthHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, HEALTH_DEBUG_PATH, nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expect 503 when degraded, got %d", rec.Code)
+	}
+	var got HealthStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || !got.Degraded {
+		t.Errorf("expect degraded status in body, got %s", rec.Body.String())
+	}
+}
+
+func TestInitMeterDegraded(t *testing.T) {
+	newMetricReader(t)
+	Metrics, RequestCount = nil, nil
+
+	// prometheus 先启动成功，随后的未知 exporter 导致失败，端口应被释放
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(lis.Addr().String())
+	lis.Close()
+	t.Setenv(METRICS_EXPORTER_ENV, "prometheus,bogus")
+	t.Setenv(PROMETHEUS_HOST_ENV, "127.0.0.1")
+	t.Setenv(PROMETHEUS_PORT_ENV, port)
+
+	if err := initMeter(context.Background(), nil); err == nil {
+		t.Fatalf("expect error for unknown exporter")
+	}
+	if Metrics == nil || RequestCount == nil {
+		t.Fatalf("expect no-op metrics registry after failure")
+	}
+	RequestCount.Add(context.Background(), 1)
+
+	lis, err = net.Listen("tcp", net.JoinHostPort("127.0.0.1", port))
+	if err != nil {
+		t.Errorf("expect prometheus port released, got %v", err)
+	} else {
+		lis.Close()
+	}
+}
+
+// trace 初始化失败后退化为 no-op provider，嵌套和并发的 span 依然正确出入栈并延续上游 trace
+func TestTraceDegradedNestedSpans(t *testing.T) {
+	resetHealth(t)
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(noop.NewTracerProvider())
+	defer otel.SetTracerProvider(prev)
+	initSignal(SignalTrace, func() error { return errors.New("no endpoint") })
+	if !Health().Degraded {
+		t.Fatalf("expect degraded status")
+	}
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	handler := HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		depth := TraceStackDepth()
+		WithSpan("outer", func(ctx context.Context) error {
+			return WithSpan("inner", func(ctx context.Context) error {
+				if TraceStackDepth() != depth+2 {
+					t.Errorf("expect depth %d, got %d", depth+2, TraceStackDepth())
+				}
+				if got := trace.SpanContextFromContext(ctx).TraceID().String(); got != traceID {
+					t.Errorf("expect trace id %s in nested span, got %s", traceID, got)
+				}
+				return nil
+			})
+		})
+		if TraceStackDepth() != depth {
+			t.Errorf("expect depth %d after nested spans, got %d", depth, TraceStackDepth())
+		}
+	}))
+	serve := func() {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	serve()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			IsolateProfLabel()
+			for j := 0; j < 50; j++ {
+				serve()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestTracePropagationWithoutExporter(t *testing.T) {
+	// 没有可用的 TracerProvider 时，上游的 trace context 依然会压入 goroutine trace 栈
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(noop.NewTracerProvider())
+	defer otel.SetTracerProvider(prev)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	var got string
+	handler := HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ctx, err := RetrieveSpanContext(context.Background()); err == nil {
+			got = trace.SpanContextFromContext(ctx).TraceID().String()
+		}
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if got != traceID {
+		t.Errorf("expect trace id %s on goroutine stack, got %q", traceID, got)
+	}
+}
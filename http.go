@@ -0,0 +1,185 @@
+package probesdk
+
+import (
+	"bufio"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"net"
+	"net/http"
+	"time"
+)
+
+type httpConfig struct {
+	routeFunc    func(r *http.Request) string
+	spanNameFunc func(r *http.Request, route string) string
+	filter       func(r *http.Request) bool
+}
+
+type HTTPOption func(*httpConfig)
+
+// WithHTTPRoute 设置从请求中获取路由模板（如 /users/{id}）的方法，用于 http.route 属性和 span 名称
+func WithHTTPRoute(f func(r *http.Request) string) HTTPOption {
+	return func(c *httpConfig) {
+		c.routeFunc = f
+	}
+}
+
+// WithHTTPSpanName 自定义 span 名称，默认为 "METHOD route"
+func WithHTTPSpanName(f func(r *http.Request, route string) string) HTTPOption {
+	return func(c *httpConfig) {
+		c.spanNameFunc = f
+	}
+}
+
+// WithHTTPFilter 设置过滤函数，返回 false 的请求不做埋点
+func WithHTTPFilter(f func(r *http.Request) bool) HTTPOption {
+	return func(c *httpConfig) {
+		c.filter = f
+	}
+}
+
+func newHTTPConfig(opts []HTTPOption) *httpConfig {
+	c := &httpConfig{
+		routeFunc: func(r *http.Request) string { return "" },
+		spanNameFunc: func(r *http.Request, route string) string {
+			if route == "" {
+				return r.Method
+			}
+			return r.Method + " " + route
+		},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// HTTPHandler 为 next 添加服务端埋点：从请求头中提取上游 trace context，开启 SERVER span 并压入
+// 处理请求的 goroutine 的 trace 栈，使深层代码可以通过 RetrieveSpanContext 获取，请求结束后出栈并记录指标
+func HTTPHandler(next http.Handler, opts ...HTTPOption) http.Handler {
+	cfg := newHTTPConfig(opts)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.filter != nil && !cfg.filter(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		route := cfg.routeFunc(r)
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := otel.Tracer(TracerName).Start(
+			ctx,
+			cfg.spanNameFunc(r, route),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(httpServerAttributes(r, route)...),
+		)
+
+		depth := TraceStackDepth()
+		OnSpanStart(span)
+
+		rw := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			rec := recover()
+			if rec != nil {
+				rw.status = http.StatusInternalServerError
+				span.SetStatus(codes.Error, fmt.Sprintf("panic: %v", rec))
+			} else if rw.status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(rw.status))
+			}
+			span.SetAttributes(semconv.HTTPStatusCode(rw.status))
+			popSpanEntry(depth)
+			recordWallClock(span)
+			span.End()
+
+			attrs := httpMetricAttributes(r, route, rw.status)
+			RequestDuration.Record(ctx, time.Since(start).Seconds(), attrs...)
+			RequestCount.Add(ctx, 1, attrs...)
+			if rec != nil {
+				panic(rec)
+			}
+		}()
+
+		next.ServeHTTP(rw, r.WithContext(ctx))
+	})
+}
+
+func httpScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+func httpServerAttributes(r *http.Request, route string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		semconv.HTTPMethod(r.Method),
+		semconv.HTTPScheme(httpScheme(r)),
+		semconv.URLPath(r.URL.Path),
+		semconv.ServerAddress(r.Host),
+	}
+	if route != "" {
+		attrs = append(attrs, semconv.HTTPRoute(route))
+	}
+	if ua := r.UserAgent(); ua != "" {
+		attrs = append(attrs, semconv.UserAgentOriginal(ua))
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		attrs = append(attrs, semconv.ClientAddress(host))
+	}
+	return attrs
+}
+
+func httpMetricAttributes(r *http.Request, route string, status int) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		semconv.HTTPRequestMethodKey.String(r.Method),
+		semconv.HTTPResponseStatusCode(status),
+		semconv.URLScheme(httpScheme(r)),
+	}
+	if route != "" {
+		attrs = append(attrs, semconv.HTTPRoute(route))
+	}
+	return attrs
+}
+
+// 记录响应状态码的 ResponseWriter
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("%T does not implement http.Hijacker", w.ResponseWriter)
+}
+
+// Unwrap 供 http.ResponseController 使用
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
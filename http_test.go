@@ -0,0 +1,123 @@
+package probesdk
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// 将请求相关的指标替换为写入 ManualReader 的实例，测试结束后恢复
+func newMetricReader(t *testing.T) *sdkmetric.ManualReader {
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	prevMetrics, prevDuration, prevCount, prevClientDuration := Metrics, RequestDuration, RequestCount, ClientRequestDuration
+	prevRPCServer, prevRPCClient := RPCServerDuration, RPCClientDuration
+	prevOverflow := MetricOverflow
+	Metrics = NewMetricRegistry(provider.Meter("test"))
+	if err := registerBuiltinInstruments(Metrics); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		Metrics, RequestDuration, RequestCount, ClientRequestDuration = prevMetrics, prevDuration, prevCount, prevClientDuration
+		RPCServerDuration, RPCClientDuration = prevRPCServer, prevRPCClient
+		MetricOverflow = prevOverflow
+	})
+	return reader
+}
+
+func collectMetric(t *testing.T, reader *sdkmetric.ManualReader, name string) metricdata.Metrics {
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name == name {
+				return m
+			}
+		}
+	}
+	t.Fatalf("metric %s not found", name)
+	return metricdata.Metrics{}
+}
+
+// 模拟拿不到 ctx 的老代码
+func legacySpanID() trace.SpanID {
+	sc, _ := GetSpanContext()
+	return sc.SpanID()
+}
+
+func TestHTTPHandler(t *testing.T) {
+	sr := newSpanRecorder(t)
+	reader := newMetricReader(t)
+
+	var gotSpanID, ctxSpanID trace.SpanID
+	handler := HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotSpanID = legacySpanID()
+		ctxSpanID = trace.SpanContextFromContext(r.Context()).SpanID()
+		w.WriteHeader(http.StatusTeapot)
+	}), WithHTTPRoute(func(r *http.Request) string { return "/users/{id}" }))
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/users/42", nil)
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	s := findSpan(t, sr, "GET /users/{id}")
+	if s.SpanKind() != trace.SpanKindServer {
+		t.Errorf("expect server span, got %v", s.SpanKind())
+	}
+	if s.Parent().TraceID().String() != "0af7651916cd43dd8448eb211c80319c" || !s.Parent().IsRemote() {
+		t.Errorf("expect remote parent from traceparent, got %v", s.Parent())
+	}
+	if gotSpanID != s.SpanContext().SpanID() || ctxSpanID != s.SpanContext().SpanID() {
+		t.Errorf("expect server span on goroutine stack and request context")
+	}
+	attrs := attribute.NewSet(s.Attributes()...)
+	if v, _ := attrs.Value(semconv.HTTPStatusCodeKey); v.AsInt64() != http.StatusTeapot {
+		t.Errorf("expect status code attribute, got %v", v)
+	}
+	if v, _ := attrs.Value(semconv.HTTPRouteKey); v.AsString() != "/users/{id}" {
+		t.Errorf("expect route attribute, got %v", v)
+	}
+
+	count := collectMetric(t, reader, "probesdk.http.server.requests").Data.(metricdata.Sum[int64])
+	if len(count.DataPoints) != 1 || count.DataPoints[0].Value != 1 {
+		t.Fatalf("expect one request counted, got %+v", count.DataPoints)
+	}
+	if v, _ := count.DataPoints[0].Attributes.Value(semconv.HTTPResponseStatusCodeKey); v.AsInt64() != http.StatusTeapot {
+		t.Errorf("expect status code on metric, got %v", v)
+	}
+}
+
+func TestHTTPHandlerPanic(t *testing.T) {
+	sr := newSpanRecorder(t)
+	newMetricReader(t)
+
+	srv := httptest.NewServer(HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err == nil {
+		resp.Body.Close()
+	}
+
+	s := findSpan(t, sr, "GET")
+	attrs := attribute.NewSet(s.Attributes()...)
+	if v, _ := attrs.Value(semconv.HTTPStatusCodeKey); v.AsInt64() != http.StatusInternalServerError {
+		t.Errorf("expect 500 status code attribute, got %v", v)
+	}
+}
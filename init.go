@@ -2,20 +2,23 @@ package probesdk
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
 	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
-	"go.opentelemetry.io/otel/metric"
-	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/log/global"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
 	metric2 "go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
-	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -23,34 +26,42 @@ import (
 const HTTP_ENDPOINT = "tracing-analysis-dc-bj.aliyuncs.com"
 const HTTP_TRACE_URL_PATH = "adapt_igkxc7d8zo@c3f99692f4e27fe_igkxc7d8zo@53df7ad2afe8301/api/otlp/traces"
 
+const HTTP_LOGS_URL_PATH = "adapt_igkxc7d8zo@c3f99692f4e27fe_igkxc7d8zo@53df7ad2afe8301/api/otlp/logs"
+
 const HTTP_METRIC_ENDPOINT = "cn-beijing.arms.aliyuncs.com"
 const HTTP_METRICS_URL_PATH = "opentelemetry/58f1a59e132c474b139cf8e4366552/1874856833619396/i8anrmcvv6/cn-beijing/api/v1/metrics"
 
-// 设置应用资源
-func newResource(ctx context.Context) *resource.Resource {
+// 设置应用资源，部分 detector 失败时返回已检测到的资源和错误
+func newResource(ctx context.Context) (*resource.Resource, error) {
 	hostName, _ := os.Hostname()
 	programName := filepath.Base(os.Args[0])
 	programName = strings.TrimSuffix(programName, filepath.Ext(programName))
 
+	// 后面的选项覆盖前面的：程序名只作为 service.name 的默认值，OTEL_RESOURCE_ATTRIBUTES、OTEL_SERVICE_NAME 和显式指定的名称依次覆盖
 	r, err := resource.New(
 		ctx,
-		resource.WithFromEnv(),
-		resource.WithProcess(),
-		resource.WithTelemetrySDK(),
-		resource.WithHost(),
 		resource.WithAttributes(
 			semconv.HostNameKey.String(hostName), // 主机名
 			semconv.ServiceNameKey.String(programName),
 		),
+		resource.WithProcess(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithDetectors(ContainerDetector{}, KubernetesDetector{}, BuildInfoDetector{}),
+		resource.WithFromEnv(),
+		resource.WithDetectors(ServiceDetector{}),
 	)
 
 	if err != nil {
-		log.Fatalf("%s: %v", "Failed to create OpenTelemetry resource", err)
+		if r == nil {
+			r = resource.Default()
+		}
+		return r, fmt.Errorf("failed to create OpenTelemetry resource: %w", err)
 	}
-	return r
+	return r, nil
 }
 
-func newHTTPExporterAndSpanProcessor(ctx context.Context) (*otlptrace.Exporter, sdktrace.SpanProcessor) {
+func newHTTPExporterAndSpanProcessor(ctx context.Context) (*otlptrace.Exporter, sdktrace.SpanProcessor, error) {
 
 	traceExporter, err := otlptrace.New(ctx, otlptracehttp.NewClient(
 		otlptracehttp.WithEndpoint(HTTP_ENDPOINT),
@@ -59,21 +70,37 @@ func newHTTPExporterAndSpanProcessor(ctx context.Context) (*otlptrace.Exporter,
 		otlptracehttp.WithCompression(1)))
 
 	if err != nil {
-		log.Fatalf("%s: %v", "Failed to create the OpenTelemetry trace exporter", err)
+		return nil, nil, fmt.Errorf("failed to create the OpenTelemetry trace exporter: %w", err)
 	}
 
 	batchSpanProcessor := sdktrace.NewBatchSpanProcessor(traceExporter)
 
-	return traceExporter, batchSpanProcessor
+	return traceExporter, batchSpanProcessor, nil
 }
 
-// InitOpenTelemetryTrace  OpenTelemetry 初始化方法
+// InitOpenTelemetryTrace  OpenTelemetry 初始化方法，失败时通过 otel.Handle 报告错误并保留 no-op 的 TracerProvider，
+// goroutine trace 栈和上下文传播仍然可用
 func InitOpenTelemetryTrace(ctx context.Context, otelResource *resource.Resource) func() {
+	shutdown, err := initOpenTelemetryTrace(ctx, otelResource)
+	if err != nil {
+		otel.Handle(err)
+	}
+	return shutdown
+}
 
-	var traceExporter *otlptrace.Exporter
-	var batchSpanProcessor sdktrace.SpanProcessor
+func initOpenTelemetryTrace(ctx context.Context, otelResource *resource.Resource) (func(), error) {
+	propagator, err := propagatorFromEnv()
+	if err != nil {
+		// 配置有误时使用默认的传播器，不影响 trace 初始化
+		otel.Handle(err)
+		propagator, _ = NewPropagator(strings.Split(DEFAULT_PROPAGATORS, ",")...)
+	}
+	otel.SetTextMapPropagator(propagator)
 
-	traceExporter, batchSpanProcessor = newHTTPExporterAndSpanProcessor(ctx)
+	traceExporter, batchSpanProcessor, err := newHTTPExporterAndSpanProcessor(ctx)
+	if err != nil {
+		return func() {}, err
+	}
 
 	traceProvider := sdktrace.NewTracerProvider(
 		sdktrace.WithSampler(sdktrace.AlwaysSample()),
@@ -81,64 +108,310 @@ func InitOpenTelemetryTrace(ctx context.Context, otelResource *resource.Resource
 		sdktrace.WithSpanProcessor(batchSpanProcessor))
 
 	otel.SetTracerProvider(traceProvider)
-	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
 
 	return func() {
 		cxt, cancel := context.WithTimeout(ctx, time.Second)
 		defer cancel()
+		// 先关闭 provider，把 batchSpanProcessor 中缓存的 span 刷出去，再关闭 exporter
+		if err := traceProvider.Shutdown(cxt); err != nil {
+			otel.Handle(err)
+		}
 		if err := traceExporter.Shutdown(cxt); err != nil {
 			otel.Handle(err)
 		}
+	}, nil
+}
+
+func newHTTPLogExporterAndProcessor(ctx context.Context, opts ...otlploghttp.Option) (*otlploghttp.Exporter, sdklog.Processor, error) {
+
+	logExporter, err := otlploghttp.New(ctx, append([]otlploghttp.Option{
+		otlploghttp.WithEndpoint(HTTP_ENDPOINT),
+		otlploghttp.WithURLPath(HTTP_LOGS_URL_PATH),
+		otlploghttp.WithInsecure(),
+		otlploghttp.WithCompression(otlploghttp.GzipCompression)}, opts...)...)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create the OpenTelemetry log exporter: %w", err)
+	}
+
+	batchLogProcessor := sdklog.NewBatchProcessor(logExporter)
+
+	return logExporter, batchLogProcessor, nil
+}
+
+func newLoggerProvider(ctx context.Context, otelResource *resource.Resource, opts ...otlploghttp.Option) (*sdklog.LoggerProvider, error) {
+	_, batchLogProcessor, err := newHTTPLogExporterAndProcessor(ctx, opts...)
+	if err != nil {
+		return nil, err
 	}
+
+	return sdklog.NewLoggerProvider(
+		sdklog.WithResource(otelResource),
+		sdklog.WithProcessor(batchLogProcessor)), nil
 }
 
-var RequestDuration metric.Float64Histogram
-var RequestCount metric.Int64Counter
+// InitOpenTelemetryLog 初始化 OpenTelemetry 日志，与 trace 使用同一个 endpoint，失败时保留 no-op 的 LoggerProvider
+func InitOpenTelemetryLog(ctx context.Context, otelResource *resource.Resource) (func(), error) {
+
+	loggerProvider, err := newLoggerProvider(ctx, otelResource)
+	if err != nil {
+		return func() {}, err
+	}
+
+	global.SetLoggerProvider(loggerProvider)
 
+	return func() {
+		cxt, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		// provider 关闭时会刷新 batch processor 并关闭 exporter
+		if err := loggerProvider.Shutdown(cxt); err != nil {
+			otel.Handle(err)
+		}
+	}, nil
+}
+
+// Metrics 是 probe 的指标注册表，业务指标也应在这里声明
+var Metrics *MetricRegistry
+
+// 内置指标，除 RequestCount 外遵循 OpenTelemetry HTTP/RPC 语义约定
+var RequestDuration *Histogram
+var RequestCount *Counter
+var ClientRequestDuration *Histogram
+var RPCServerDuration *Histogram
+var RPCClientDuration *Histogram
+
+// probe 自身的指标：属性组合溢出的次数
+var MetricOverflow *Counter
+
+// 按 METRICS_EXPORTER_ENV 创建 reader，OTLP 推送和 Prometheus 拉取可以同时启用
+func newMetricReaders(ctx context.Context) (readers []metric2.Reader, err error) {
+	defer func() {
+		// 失败时关闭已创建的 reader，避免 Prometheus 端口被占用
+		if err != nil {
+			for _, reader := range readers {
+				reader.Shutdown(ctx)
+			}
+			readers = nil
+		}
+	}()
+	for _, name := range strings.Split(getenvDefault(METRICS_EXPORTER_ENV, "otlp"), ",") {
+		switch strings.TrimSpace(name) {
+		case "otlp":
+			exporter, err := otlpmetrichttp.New(
+				ctx,
+				otlpmetrichttp.WithEndpoint(HTTP_METRIC_ENDPOINT),
+				otlpmetrichttp.WithURLPath(HTTP_METRICS_URL_PATH),
+				otlpmetrichttp.WithInsecure(),
+				//otlpmetrichttp.WithHeaders(map[string]string{
+				//	"Authorization": "Bearer YOUR_TOKEN", // 阿里云要求的认证头
+				//}),
+			)
+			if err != nil {
+				return readers, fmt.Errorf("failed to create the OpenTelemetry metric exporter: %w", err)
+			}
+			readers = append(readers, metric2.NewPeriodicReader(exporter, metric2.WithInterval(5*time.Second)))
+		case "prometheus":
+			reader, err := newPrometheusReaderFromEnv()
+			if err != nil {
+				return readers, fmt.Errorf("failed to start prometheus reader: %w", err)
+			}
+			readers = append(readers, reader)
+		case "none", "":
+		default:
+			return readers, fmt.Errorf("unknown metrics exporter %q", name)
+		}
+	}
+	return readers, nil
+}
+
+// 初始化 MeterProvider，失败时保留 no-op 的 MeterProvider，Metrics 中的句柄仍然可用
 func initMeter(ctx context.Context, otelResource *resource.Resource) error {
-	exporter, err := otlpmetrichttp.New(
-		ctx,
-		otlpmetrichttp.WithEndpoint(HTTP_METRIC_ENDPOINT),
-		otlpmetrichttp.WithURLPath(HTTP_METRICS_URL_PATH),
-		otlpmetrichttp.WithInsecure(),
-		//otlpmetrichttp.WithHeaders(map[string]string{
-		//	"Authorization": "Bearer YOUR_TOKEN", // 阿里云要求的认证头
-		//}),
-	)
+	readers, err := newMetricReaders(ctx)
+	if err != nil {
+		return errors.Join(err, newProbeMetrics())
+	}
+
+	views, err := loadViewsFromEnv()
+	if err != nil {
+		// view 配置有误时退回默认 view，不影响指标上报
+		otel.Handle(err)
+		views, _ = NewViews(nil)
+	}
+
+	filter, err := exemplarFilterFromEnv()
 	if err != nil {
-		panic(err)
+		otel.Handle(err)
+		filter, _ = ExemplarFilter("")
 	}
 
 	// 4. 注册全局 MeterProvider
 	// 3. 创建 MeterProvider
-	provider := metric2.NewMeterProvider(
-		metric2.WithResource(otelResource),
-		metric2.WithReader(metric2.NewPeriodicReader(
-			exporter, metric2.WithInterval(5*time.Second))))
+	opts := []metric2.Option{metric2.WithResource(otelResource), metric2.WithView(views...), metric2.WithExemplarFilter(filter)}
+	for _, reader := range readers {
+		opts = append(opts, metric2.WithReader(reader))
+	}
+	provider := metric2.NewMeterProvider(opts...)
 
 	otel.SetMeterProvider(provider)
-	meter := otel.Meter("")
-	RequestDuration, _ = meter.Float64Histogram(
-		"http.request.duration",
-		metric.WithUnit("ms"),
-	)
-	RequestCount, _ = meter.Int64Counter(
-		"http.request.count",
-	)
+	shutdownFuncs = append(shutdownFuncs, func() {
+		cxt, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		if err := provider.Shutdown(cxt); err != nil {
+			otel.Handle(err)
+		}
+	})
+
+	return newProbeMetrics()
+}
 
+// 在全局 MeterProvider 上创建 Metrics 注册表和内置指标
+func newProbeMetrics() error {
+	Metrics = NewMetricRegistry(otel.Meter(TracerName))
+	if v := os.Getenv(CARDINALITY_LIMIT_ENV); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			otel.Handle(fmt.Errorf("invalid %s: %w", CARDINALITY_LIMIT_ENV, err))
+		} else {
+			Metrics.SetCardinalityLimit(limit)
+		}
+	}
+	if err := registerBuiltinInstruments(Metrics); err != nil {
+		return err
+	}
+	if runtimeMetricsEnabled() {
+		return RegisterRuntimeMetrics(Metrics)
+	}
 	return nil
 }
 
+// 内置指标统一经注册表声明，http/transport/grpc 只使用这里返回的句柄，属性按 AttributeKeys 过滤。
+// HTTP 指标的属性使用当前的语义约定（http.request.method、http.response.status_code 等），
+// 与 span 上沿用的旧属性名不同
+func registerBuiltinInstruments(r *MetricRegistry) error {
+	var err error
+	httpServerKeys := []attribute.Key{
+		semconv.HTTPRequestMethodKey, semconv.HTTPResponseStatusCodeKey, semconv.HTTPRouteKey, semconv.URLSchemeKey,
+	}
+	httpClientKeys := []attribute.Key{
+		semconv.HTTPRequestMethodKey, semconv.HTTPResponseStatusCodeKey, semconv.ServerAddressKey, semconv.ServerPortKey,
+	}
+	rpcKeys := []attribute.Key{
+		semconv.RPCSystemKey, semconv.RPCServiceKey, semconv.RPCMethodKey, semconv.RPCGRPCStatusCodeKey,
+	}
+
+	if RequestDuration, err = r.Histogram(InstrumentSpec{
+		Name:          "http.server.request.duration",
+		Description:   "Duration of HTTP server requests.",
+		Unit:          "s",
+		AttributeKeys: httpServerKeys,
+	}); err != nil {
+		return err
+	}
+	// 语义约定中没有请求数指标（可由 duration 直方图的 count 得到），这里保留原来的计数器，放在 probesdk 命名空间下
+	if RequestCount, err = r.Counter(InstrumentSpec{
+		Name:          "probesdk.http.server.requests",
+		Description:   "Number of HTTP server requests.",
+		Unit:          "{request}",
+		AttributeKeys: httpServerKeys,
+	}); err != nil {
+		return err
+	}
+	if ClientRequestDuration, err = r.Histogram(InstrumentSpec{
+		Name:          "http.client.request.duration",
+		Description:   "Duration of HTTP client requests.",
+		Unit:          "s",
+		AttributeKeys: httpClientKeys,
+	}); err != nil {
+		return err
+	}
+	if RPCServerDuration, err = r.Histogram(InstrumentSpec{
+		Name:          "rpc.server.duration",
+		Description:   "Duration of inbound RPCs.",
+		Unit:          "ms",
+		AttributeKeys: rpcKeys,
+	}); err != nil {
+		return err
+	}
+	if RPCClientDuration, err = r.Histogram(InstrumentSpec{
+		Name:          "rpc.client.duration",
+		Description:   "Duration of outbound RPCs.",
+		Unit:          "ms",
+		AttributeKeys: rpcKeys,
+	}); err != nil {
+		return err
+	}
+	if err = r.ObservableCounter(InstrumentSpec{
+		Name:          "probesdk.trace_stack.mismatch",
+		Description:   "Push/pop mismatches on the goroutine trace stack.",
+		Unit:          "{entry}",
+		AttributeKeys: []attribute.Key{"reason"},
+	}, observeStackMismatches); err != nil {
+		return err
+	}
+	// 溢出计数自身不做限制，否则溢出时会递归记录
+	MetricOverflow, err = r.Counter(InstrumentSpec{
+		Name:             "probesdk.metric.overflow",
+		Description:      "Recordings collapsed into the overflow series after hitting the cardinality limit.",
+		Unit:             "{recording}",
+		AttributeKeys:    []attribute.Key{"instrument"},
+		CardinalityLimit: -1,
+	})
+	return err
+}
+
+// init 中各信号的关闭方法，按初始化的逆序调用
+var shutdownFuncs []func()
+
+// Shutdown 刷新并关闭 trace、metric、log 流水线，通常在进程退出前调用
+func Shutdown() {
+	for i := len(shutdownFuncs) - 1; i >= 0; i-- {
+		shutdownFuncs[i]()
+	}
+	shutdownFuncs = nil
+}
+
 func init() {
 	fmt.Println(".............Init Probe ...........")
 	ctx := context.Background()
-	otelResource := newResource(ctx)
-
-	_ = InitOpenTelemetryTrace(ctx, otelResource)
 
-	err := initMeter(ctx, otelResource)
-	if err != nil {
-		fmt.Println(err)
+	// 各信号独立初始化，失败的信号退化为 no-op，不影响宿主进程和其他信号
+	otelResource := resource.Default()
+	initSignal(SignalResource, func() error {
+		r, err := newResource(ctx)
+		otelResource = r
+		return err
+	})
+	initSignal(SignalTrace, func() error {
+		shutdown, err := initOpenTelemetryTrace(ctx, otelResource)
+		shutdownFuncs = append(shutdownFuncs, shutdown)
+		return err
+	})
+	initSignal(SignalMetric, func() error {
+		return initMeter(ctx, otelResource)
+	})
+	initSignal(SignalLog, func() error {
+		shutdown, err := InitOpenTelemetryLog(ctx, otelResource)
+		shutdownFuncs = append(shutdownFuncs, shutdown)
+		return err
+	})
+	initSignal(SignalProfile, func() error {
+		shutdown, err := InitProfiler(ctx, otelResource)
+		shutdownFuncs = append(shutdownFuncs, shutdown)
+		return err
+	})
+	initSignal(SignalOffCPU, func() error {
+		shutdown, err := InitOffCPUSampler(ctx)
+		shutdownFuncs = append(shutdownFuncs, shutdown)
+		return err
+	})
+	if shutdown, err := InitDebugServer(ctx); err != nil {
+		otel.Handle(err)
+	} else {
+		shutdownFuncs = append(shutdownFuncs, shutdown)
 	}
-
+	if err := initProfilingLabels(); err != nil {
+		otel.Handle(err)
+	}
+	loadEnvParent()
+	warnIfDegraded()
 }
@@ -2,9 +2,94 @@ package probesdk
 
 import (
 	"context"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	"go.opentelemetry.io/otel/log/global"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
 )
 
 func TestMetrics(t *testing.T) {
 	RequestCount.Add(context.Background(), 1)
 }
+
+// 本地的 OTLP/HTTP 日志接收端
+type otlpLogServer struct {
+	mu      sync.Mutex
+	records []*logspb.ResourceLogs
+}
+
+func (s *otlpLogServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	req := &collogspb.ExportLogsServiceRequest{}
+	if err := proto.Unmarshal(body, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.records = append(s.records, req.ResourceLogs...)
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	out, _ := proto.Marshal(&collogspb.ExportLogsServiceResponse{})
+	w.Write(out)
+}
+
+func TestLogPipeline(t *testing.T) {
+	sr := newSpanRecorder(t)
+	ctx := context.Background()
+	receiver := &otlpLogServer{}
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+
+	res, _ := newResource(ctx)
+	provider, err := newLoggerProvider(ctx, res,
+		otlploghttp.WithEndpointURL(srv.URL+"/v1/logs"),
+		otlploghttp.WithCompression(otlploghttp.NoCompression))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := global.GetLoggerProvider()
+	global.SetLoggerProvider(provider)
+	defer global.SetLoggerProvider(prev)
+
+	logger := slog.New(NewOTelSlogHandler("test"))
+	_ = WithSpan("op", func(_ context.Context) error {
+		logger.Info("hello", "user", "bob")
+		return nil
+	})
+	if err := provider.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	if len(receiver.records) != 1 {
+		t.Fatalf("expect one resource logs, got %d", len(receiver.records))
+	}
+	rl := receiver.records[0]
+	hasServiceName := false
+	for _, kv := range rl.Resource.Attributes {
+		if kv.Key == "service.name" {
+			hasServiceName = true
+		}
+	}
+	if !hasServiceName {
+		t.Errorf("expect service.name in resource, got %v", rl.Resource.Attributes)
+	}
+	logs := rl.ScopeLogs[0].LogRecords
+	if len(logs) != 1 || logs[0].Body.GetStringValue() != "hello" {
+		t.Fatalf("expect hello log record, got %v", logs)
+	}
+	span := findSpan(t, sr, "op")
+	traceID := span.SpanContext().TraceID()
+	spanID := span.SpanContext().SpanID()
+	if string(logs[0].TraceId) != string(traceID[:]) || string(logs[0].SpanId) != string(spanID[:]) {
+		t.Errorf("expect log record correlated with span")
+	}
+}
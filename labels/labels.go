@@ -0,0 +1,147 @@
+// Package labels 编码和解析 probesdk 写在 goroutine pprof 标签上的 trace 栈。
+// 本包没有 init 副作用，离线处理 profile 的工具引用本包即可，不会触发 probesdk 的遥测初始化
+package labels
+
+import (
+	"fmt"
+	"github.com/google/pprof/profile"
+	"go.opentelemetry.io/otel/trace"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const GRTTraceContextPrefix = "GRTTraceContextKey/"
+const GRTTraceContextLen = GRTTraceContextPrefix + "Idx"
+
+const TraceIDSize = 16
+const SpanIDSize = 8
+const TraceFlagsSize = 1
+const RemoteFlagSize = 1 // use one byte to store boolean
+
+const TraceIDStart = 0
+const SpanIDStart = TraceIDStart + TraceIDSize
+const TraceFlagsStart = SpanIDStart + SpanIDSize
+const RemoteFlagStart = TraceFlagsStart + TraceFlagsSize
+const TraceStatesStart = RemoteFlagStart + RemoteFlagSize
+
+const ByteBufferStartSize = TraceStatesStart
+
+// 可读的 span 标签，DecodeProfileLabels 和开启 profiling 标签时使用
+const (
+	ProfTraceIDLabel  = "trace_id"
+	ProfSpanIDLabel   = "span_id"
+	ProfSpanNameLabel = "span_name"
+	// ProfTraceStackLabel 由 DecodeProfileLabels 写入，按栈底到栈顶列出 span_id，以 > 分隔
+	ProfTraceStackLabel = "trace_stack"
+)
+
+// 创建内存池
+var bytePool = &sync.Pool{
+	New: func() interface{} {
+		// 每次新建一个长度为26的字节切片
+		return make([]byte, ByteBufferStartSize)
+	},
+}
+
+// DecodeTraceContext 解析 EncodeTraceContext 编码的标签值，非法时返回空的 SpanContext
+func DecodeTraceContext(data string) trace.SpanContext {
+	sc, _ := ParseTraceContext(data)
+	return sc
+}
+
+// ParseTraceContext 同 DecodeTraceContext，长度不足或 tracestate 非法时返回错误
+func ParseTraceContext(data string) (trace.SpanContext, error) {
+	if len(data) < TraceStatesStart {
+		return trace.SpanContext{}, fmt.Errorf("trace context label too short: %d bytes", len(data))
+	}
+	traceState, err := trace.ParseTraceState(data[TraceStatesStart:])
+	if err != nil {
+		return trace.SpanContext{}, err
+	}
+	isRemote := false
+	if data[RemoteFlagStart] != 0 {
+		isRemote = true
+	}
+	config := trace.SpanContextConfig{
+		TraceState: traceState,
+		Remote:     isRemote,
+	}
+	copy(config.TraceID[:], data[TraceIDStart:SpanIDStart])
+	copy(config.SpanID[:], data[SpanIDStart:TraceFlagsStart])
+	config.TraceFlags = trace.TraceFlags(data[TraceFlagsStart])
+	return trace.NewSpanContext(config), nil
+}
+
+func EncodeTraceContext(ctx trace.SpanContext) string {
+	traceStateStr := ctx.TraceState().String()
+	bytes := bytePool.Get().([]byte)
+	defer bytePool.Put(bytes)
+	traceID := ctx.TraceID()
+	spanID := ctx.SpanID()
+	copy(bytes[TraceIDStart:SpanIDStart], traceID[:])
+	copy(bytes[SpanIDStart:TraceFlagsStart], spanID[:])
+	bytes[TraceFlagsStart] = byte(ctx.TraceFlags())
+	// 切片来自内存池，不是 remote 时也要清零
+	bytes[RemoteFlagStart] = 0
+	if ctx.IsRemote() {
+		bytes[RemoteFlagStart] = 1
+	}
+	if len(traceStateStr) == 0 {
+		return string(bytes)
+	}
+	return string(bytes) + traceStateStr
+}
+
+// StackKey 返回 trace 栈第 idx 个条目的标签名，栈底为 0
+func StackKey(idx int) string {
+	return strconv.Itoa(idx)
+}
+
+// IsTraceStackLabel 判断是否为 trace 栈的长度或条目标签，可读的 trace_id/span_id 标签不算
+func IsTraceStackLabel(key string) bool {
+	if strings.HasPrefix(key, GRTTraceContextPrefix) {
+		return true
+	}
+	_, err := strconv.Atoi(key)
+	return err == nil
+}
+
+// DecodeProfileLabels 将样本上 trace 栈的编码标签改写为可读的 trace_id/span_id（栈顶 span）和 trace_stack 标签，
+// 并删除编码标签，改写后的 profile 可直接用 go tool pprof -tagfocus 等按 trace 过滤
+func DecodeProfileLabels(p *profile.Profile) {
+	for _, s := range p.Sample {
+		size, _ := strconv.Atoi(first(s.Label[GRTTraceContextLen]))
+		var stack []string
+		var top trace.SpanContext
+		for i := 0; i < size; i++ {
+			sc, err := ParseTraceContext(first(s.Label[StackKey(i)]))
+			if err != nil {
+				continue
+			}
+			top = sc
+			stack = append(stack, top.SpanID().String())
+		}
+		for k := range s.Label {
+			if IsTraceStackLabel(k) {
+				delete(s.Label, k)
+			}
+		}
+		if len(stack) == 0 {
+			continue
+		}
+		if s.Label == nil {
+			s.Label = map[string][]string{}
+		}
+		s.Label[ProfTraceIDLabel] = []string{top.TraceID().String()}
+		s.Label[ProfSpanIDLabel] = []string{top.SpanID().String()}
+		s.Label[ProfTraceStackLabel] = []string{strings.Join(stack, ">")}
+	}
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
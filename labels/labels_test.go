@@ -0,0 +1,60 @@
+package labels
+
+import (
+	"github.com/google/pprof/profile"
+	"go.opentelemetry.io/otel/trace"
+	"testing"
+)
+
+func testSpanContext(remote bool) trace.SpanContext {
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	state, _ := trace.ParseTraceState("vendor=value")
+	return trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled, TraceState: state, Remote: remote})
+}
+
+func TestParseTraceContext(t *testing.T) {
+	for _, sc := range []trace.SpanContext{testSpanContext(true), testSpanContext(false)} {
+		got, err := ParseTraceContext(EncodeTraceContext(sc))
+		if err != nil || !got.Equal(sc) {
+			t.Errorf("expect %v, got %v %v", sc, got, err)
+		}
+	}
+
+	for _, data := range []string{"", "short", EncodeTraceContext(testSpanContext(false))[:TraceStatesStart-1]} {
+		if _, err := ParseTraceContext(data); err == nil {
+			t.Errorf("expect error for %d-byte label", len(data))
+		}
+	}
+}
+
+func TestDecodeTraceContext(t *testing.T) {
+	sc := testSpanContext(false)
+	if got := DecodeTraceContext(EncodeTraceContext(sc)); !got.Equal(sc) {
+		t.Errorf("expect %v, got %v", sc, got)
+	}
+	if got := DecodeTraceContext("short"); got.IsValid() {
+		t.Errorf("expect empty span context for bad label, got %v", got)
+	}
+}
+
+func TestDecodeProfileLabels(t *testing.T) {
+	sc := testSpanContext(false)
+	p := &profile.Profile{Sample: []*profile.Sample{
+		{Label: map[string][]string{GRTTraceContextLen: {"1"}, StackKey(0): {EncodeTraceContext(sc)}, "region": {"bj"}}},
+		// 损坏的条目被跳过
+		{Label: map[string][]string{GRTTraceContextLen: {"1"}, StackKey(0): {"bad"}}},
+	}}
+	DecodeProfileLabels(p)
+
+	l := p.Sample[0].Label
+	if first(l[ProfTraceIDLabel]) != sc.TraceID().String() || first(l[ProfTraceStackLabel]) != sc.SpanID().String() {
+		t.Errorf("expect decoded labels, got %v", l)
+	}
+	if _, ok := l[GRTTraceContextLen]; ok || first(l["region"]) != "bj" {
+		t.Errorf("expect only trace stack labels removed, got %v", l)
+	}
+	if len(p.Sample[1].Label) != 0 {
+		t.Errorf("expect corrupted stack dropped, got %v", p.Sample[1].Label)
+	}
+}
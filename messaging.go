@@ -0,0 +1,211 @@
+package probesdk
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/proto"
+)
+
+// Kafka 风格的消息头，kafka-go 的 Header 等底层类型相同的类型都可以直接使用
+type MessageHeader = struct {
+	Key   string
+	Value []byte
+}
+
+// HeaderSliceCarrier 让 []MessageHeader 形式的消息头实现 propagation.TextMapCarrier，Set 会覆盖同名的头
+type HeaderSliceCarrier[H ~MessageHeader] struct {
+	Headers *[]H
+}
+
+// NewHeaderSliceCarrier 包装消息头切片的指针，Set 时会追加到切片上
+func NewHeaderSliceCarrier[H ~MessageHeader](headers *[]H) HeaderSliceCarrier[H] {
+	return HeaderSliceCarrier[H]{Headers: headers}
+}
+
+func (c HeaderSliceCarrier[H]) Get(key string) string {
+	for _, h := range *c.Headers {
+		if header := MessageHeader(h); header.Key == key {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+func (c HeaderSliceCarrier[H]) Set(key, value string) {
+	for i, h := range *c.Headers {
+		if MessageHeader(h).Key == key {
+			(*c.Headers)[i] = H(MessageHeader{Key: key, Value: []byte(value)})
+			return
+		}
+	}
+	*c.Headers = append(*c.Headers, H(MessageHeader{Key: key, Value: []byte(value)}))
+}
+
+func (c HeaderSliceCarrier[H]) Keys() []string {
+	keys := make([]string, 0, len(*c.Headers))
+	for _, h := range *c.Headers {
+		keys = append(keys, MessageHeader(h).Key)
+	}
+	return keys
+}
+
+// ByteMapCarrier 让 map[string][]byte 形式的消息头实现 propagation.TextMapCarrier
+type ByteMapCarrier map[string][]byte
+
+func (c ByteMapCarrier) Get(key string) string {
+	return string(c[key])
+}
+
+func (c ByteMapCarrier) Set(key, value string) {
+	c[key] = []byte(value)
+}
+
+func (c ByteMapCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// TRACE_CONTEXT_BIN_HEADER 是紧凑二进制格式使用的头，值为 TraceContextProto 的序列化结果
+const TRACE_CONTEXT_BIN_HEADER = "probesdk-trace-bin"
+
+// MarshalTraceContext 将 span context 序列化为 TraceContextProto
+func MarshalTraceContext(sc trace.SpanContext) ([]byte, error) {
+	traceID := sc.TraceID()
+	spanID := sc.SpanID()
+	return proto.Marshal(&TraceContextProto{
+		TraceId:    traceID[:],
+		SpanId:     spanID[:],
+		TraceFlags: []byte{byte(sc.TraceFlags())},
+		TraceState: sc.TraceState().String(),
+		Remote:     sc.IsRemote(),
+	})
+}
+
+// UnmarshalTraceContext 解析 MarshalTraceContext 的结果
+func UnmarshalTraceContext(data []byte) (trace.SpanContext, error) {
+	var tc TraceContextProto
+	if err := proto.Unmarshal(data, &tc); err != nil {
+		return trace.SpanContext{}, err
+	}
+	if len(tc.TraceId) != TraceIDSize || len(tc.SpanId) != SpanIDSize || len(tc.TraceFlags) != TraceFlagsSize {
+		return trace.SpanContext{}, fmt.Errorf("invalid trace context: trace_id %d bytes, span_id %d bytes, trace_flags %d bytes",
+			len(tc.TraceId), len(tc.SpanId), len(tc.TraceFlags))
+	}
+	traceState, err := trace.ParseTraceState(tc.TraceState)
+	if err != nil {
+		return trace.SpanContext{}, err
+	}
+	cfg := trace.SpanContextConfig{
+		TraceFlags: trace.TraceFlags(tc.TraceFlags[0]),
+		TraceState: traceState,
+		Remote:     tc.Remote,
+	}
+	copy(cfg.TraceID[:], tc.TraceId)
+	copy(cfg.SpanID[:], tc.SpanId)
+	return trace.NewSpanContext(cfg), nil
+}
+
+// BinaryPropagator 以单个二进制头传播 span context，不携带 baggage。
+// 值不是可打印字符，只适用于 HeaderSliceCarrier、ByteMapCarrier 这类以字节保存头的载体
+type BinaryPropagator struct{}
+
+func (BinaryPropagator) Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	data, err := MarshalTraceContext(sc)
+	if err != nil {
+		otel.Handle(err)
+		return
+	}
+	carrier.Set(TRACE_CONTEXT_BIN_HEADER, string(data))
+}
+
+func (BinaryPropagator) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	v := carrier.Get(TRACE_CONTEXT_BIN_HEADER)
+	if v == "" {
+		return ctx
+	}
+	sc, err := UnmarshalTraceContext([]byte(v))
+	if err != nil || !sc.IsValid() {
+		return ctx
+	}
+	return trace.ContextWithRemoteSpanContext(ctx, sc.WithRemote(true))
+}
+
+func (BinaryPropagator) Fields() []string {
+	return []string{TRACE_CONTEXT_BIN_HEADER}
+}
+
+// MessagingPropagator 生产者和消费者辅助函数使用的传播器，为 nil 时使用全局传播器。
+// 内部队列可设置为 BinaryPropagator{} 只写一个头
+var MessagingPropagator propagation.TextMapPropagator
+
+func messagingPropagator() propagation.TextMapPropagator {
+	if MessagingPropagator != nil {
+		return MessagingPropagator
+	}
+	return otel.GetTextMapPropagator()
+}
+
+func messagingAttributes(system, destination string, operation attribute.KeyValue) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		semconv.MessagingSystem(system),
+		semconv.MessagingDestinationName(destination),
+		operation,
+	}
+}
+
+// StartProducerSpan 开启 PRODUCER span 并把 trace context 注入消息头，ctx 中没有 span 时以当前 goroutine
+// trace 栈顶的 span 为父 span。span 不入栈，调用方在发送完成（或异步回调）时 End
+func StartProducerSpan(ctx context.Context, system, destination string, carrier propagation.TextMapCarrier, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	ctx, span := otel.Tracer(TracerName).Start(
+		EnsureSpanContext(ctx),
+		destination+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(messagingAttributes(system, destination, semconv.MessagingOperationPublish)...),
+		trace.WithAttributes(attrs...),
+	)
+	messagingPropagator().Inject(ctx, carrier)
+	return ctx, span
+}
+
+// StartConsumerSpan 从消息头提取生产者的 trace context，开启 CONSUMER span 并压入处理消息的 goroutine 的 trace 栈，
+// 调用方需要紧接着 defer EndSpan(&err)。ctx 中已有的 span 被消息中的 span 取代
+func StartConsumerSpan(ctx context.Context, system, destination string, carrier propagation.TextMapCarrier, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	ctx = messagingPropagator().Extract(ctx, carrier)
+	return StartSpan(ctx, destination+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(messagingAttributes(system, destination, semconv.MessagingOperationProcess)...),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// StartBatchConsumerSpan 为一批消息开启一个 CONSUMER span，每条消息的 trace context 作为 span link，
+// 父 span 取自 ctx 或当前 goroutine 的 trace 栈。与 StartConsumerSpan 一样入栈，需要 defer EndSpan(&err)
+func StartBatchConsumerSpan(ctx context.Context, system, destination string, carriers []propagation.TextMapCarrier, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	propagator := messagingPropagator()
+	links := make([]trace.Link, 0, len(carriers))
+	for _, carrier := range carriers {
+		sc := trace.SpanContextFromContext(propagator.Extract(context.Background(), carrier))
+		if sc.IsValid() {
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+	}
+	return StartSpan(ctx, destination+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(links...),
+		trace.WithAttributes(messagingAttributes(system, destination, semconv.MessagingOperationProcess)...),
+		trace.WithAttributes(semconv.MessagingBatchMessageCount(len(carriers))),
+		trace.WithAttributes(attrs...),
+	)
+}
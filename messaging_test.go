@@ -0,0 +1,150 @@
+package probesdk
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"testing"
+)
+
+// 与 kafka-go 的 Header 底层类型相同
+type kafkaHeader struct {
+	Key   string
+	Value []byte
+}
+
+func TestMessageCarriers(t *testing.T) {
+	var headers []kafkaHeader
+	carriers := map[string]propagation.TextMapCarrier{
+		"header slice": NewHeaderSliceCarrier(&headers),
+		"byte map":     ByteMapCarrier{},
+	}
+	for name, c := range carriers {
+		c.Set("traceparent", "a")
+		c.Set("traceparent", "b")
+		c.Set("baggage", "k=v")
+		if c.Get("traceparent") != "b" || c.Get("baggage") != "k=v" || c.Get("missing") != "" {
+			t.Errorf("%s: unexpected values", name)
+		}
+		if len(c.Keys()) != 2 {
+			t.Errorf("%s: expect 2 keys, got %v", name, c.Keys())
+		}
+	}
+	if len(headers) != 2 || string(headers[0].Value) != "b" {
+		t.Errorf("expect set to replace existing header, got %v", headers)
+	}
+}
+
+func TestBinaryPropagator(t *testing.T) {
+	ts, _ := trace.ParseTraceState("vendor=value")
+	sc := testSpanContext.WithTraceState(ts)
+	carrier := ByteMapCarrier{}
+	p := BinaryPropagator{}
+	p.Inject(trace.ContextWithSpanContext(context.Background(), sc), carrier)
+	if len(carrier) != 1 {
+		t.Fatalf("expect single header, got %v", carrier.Keys())
+	}
+	got := trace.SpanContextFromContext(p.Extract(context.Background(), carrier))
+	if !got.Equal(sc.WithRemote(true)) {
+		t.Errorf("expect %v, got %v", sc, got)
+	}
+
+	for _, data := range [][]byte{[]byte("garbage"), {0x0a, 0x02, 0x01, 0x02}} {
+		if _, err := UnmarshalTraceContext(data); err == nil {
+			t.Errorf("expect error for %x", data)
+		}
+	}
+}
+
+func TestProducerConsumerSpans(t *testing.T) {
+	sr := newSpanRecorder(t)
+	prev := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTextMapPropagator(prev)
+
+	var headers []kafkaHeader
+	_, producer := StartProducerSpan(context.Background(), "kafka", "orders", NewHeaderSliceCarrier(&headers))
+	producer.End()
+	if TraceStackDepth() != 0 {
+		t.Errorf("expect producer span not pushed")
+	}
+
+	depth := TraceStackDepth()
+	func() {
+		var err error
+		_, consumer := StartConsumerSpan(context.Background(), "kafka", "orders", NewHeaderSliceCarrier(&headers))
+		defer EndSpan(&err)
+		if TraceStackDepth() != depth+1 {
+			t.Errorf("expect consumer span pushed")
+		}
+		if ActiveSpan().SpanContext().SpanID() != consumer.SpanContext().SpanID() {
+			t.Errorf("expect consumer span on top of stack")
+		}
+	}()
+	if TraceStackDepth() != depth {
+		t.Errorf("expect consumer span popped")
+	}
+
+	p := findSpan(t, sr, "orders publish")
+	c := findSpan(t, sr, "orders process")
+	if p.SpanKind() != trace.SpanKindProducer || c.SpanKind() != trace.SpanKindConsumer {
+		t.Errorf("unexpected span kinds %v %v", p.SpanKind(), c.SpanKind())
+	}
+	if c.Parent().SpanID() != p.SpanContext().SpanID() || c.Parent().TraceID() != p.SpanContext().TraceID() {
+		t.Errorf("expect consumer span child of producer span")
+	}
+	for _, want := range []interface{}{semconv.MessagingSystem("kafka"), semconv.MessagingDestinationName("orders"), semconv.MessagingOperationProcess} {
+		found := false
+		for _, kv := range c.Attributes() {
+			if kv == want {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("expect attribute %v on consumer span", want)
+		}
+	}
+}
+
+func TestBatchConsumerSpan(t *testing.T) {
+	sr := newSpanRecorder(t)
+	MessagingPropagator = BinaryPropagator{}
+	defer func() { MessagingPropagator = nil }()
+
+	var carriers []propagation.TextMapCarrier
+	var producers []trace.SpanContext
+	for i := 0; i < 3; i++ {
+		c := ByteMapCarrier{}
+		_, span := StartProducerSpan(context.Background(), "internal", "jobs", c)
+		span.End()
+		carriers = append(carriers, c)
+		producers = append(producers, span.SpanContext())
+	}
+	carriers = append(carriers, ByteMapCarrier{})
+
+	func() {
+		StartBatchConsumerSpan(context.Background(), "internal", "jobs", carriers)
+		defer EndSpan(nil)
+	}()
+
+	batch := findSpan(t, sr, "jobs process")
+	if len(batch.Links()) != len(producers) {
+		t.Fatalf("expect %d links, got %d", len(producers), len(batch.Links()))
+	}
+	for i, l := range batch.Links() {
+		if l.SpanContext.SpanID() != producers[i].SpanID() {
+			t.Errorf("expect link %d to producer span", i)
+		}
+	}
+	found := false
+	for _, kv := range batch.Attributes() {
+		if kv == semconv.MessagingBatchMessageCount(4) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expect batch message count, got %v", batch.Attributes())
+	}
+}
@@ -0,0 +1,291 @@
+package probesdk
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"reflect"
+	"regexp"
+	"sync"
+)
+
+// InstrumentKind 指标类型
+type InstrumentKind int
+
+const (
+	CounterKind InstrumentKind = iota
+	UpDownCounterKind
+	HistogramKind
+	GaugeKind
+	ObservableCounterKind
+	ObservableUpDownCounterKind
+	ObservableGaugeKind
+)
+
+func (k InstrumentKind) String() string {
+	switch k {
+	case CounterKind:
+		return "Counter"
+	case UpDownCounterKind:
+		return "UpDownCounter"
+	case HistogramKind:
+		return "Histogram"
+	case GaugeKind:
+		return "Gauge"
+	case ObservableCounterKind:
+		return "ObservableCounter"
+	case ObservableUpDownCounterKind:
+		return "ObservableUpDownCounter"
+	case ObservableGaugeKind:
+		return "ObservableGauge"
+	}
+	return fmt.Sprintf("InstrumentKind(%d)", int(k))
+}
+
+// InstrumentSpec 声明一个指标
+type InstrumentSpec struct {
+	Name        string
+	Description string
+	Unit        string
+	// AttributeKeys 允许记录的属性 key，其余属性在记录时被丢弃；为空表示不限制
+	AttributeKeys []attribute.Key
+	// CardinalityLimit 不同属性组合的上限，超出后的新组合记入溢出序列；0 使用注册表的默认值，负数表示不限制
+	CardinalityLimit int
+}
+
+// 指标名由小写字母、数字和下划线组成，以 . 分隔命名空间，如 http.server.request.duration
+var instrumentNameRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]*(\.[a-z0-9_]+)*$`)
+
+// ObserveFunc 是异步指标的回调，每次采集时调用 observe 上报当前值
+type ObserveFunc func(ctx context.Context, observe func(value float64, attrs ...attribute.KeyValue)) error
+
+// MetricRegistry 统一声明指标，重复声明同名同定义的指标会返回同一个句柄，定义冲突时返回错误
+type MetricRegistry struct {
+	meter            metric.Meter
+	mu               sync.Mutex
+	instruments      map[string]*registeredInstrument
+	cardinalityLimit int
+	limiters         map[string]*cardinalityLimiter
+}
+
+type registeredInstrument struct {
+	kind   InstrumentKind
+	spec   InstrumentSpec
+	handle interface{}
+}
+
+func NewMetricRegistry(meter metric.Meter) *MetricRegistry {
+	return &MetricRegistry{
+		meter:            meter,
+		instruments:      make(map[string]*registeredInstrument),
+		cardinalityLimit: DEFAULT_CARDINALITY_LIMIT,
+		limiters:         make(map[string]*cardinalityLimiter),
+	}
+}
+
+// 校验并登记 spec，已存在相同定义时返回已有句柄
+func (r *MetricRegistry) register(kind InstrumentKind, spec InstrumentSpec, create func() (interface{}, error)) (interface{}, error) {
+	if !instrumentNameRegexp.MatchString(spec.Name) {
+		return nil, fmt.Errorf("invalid instrument name %q: must be lowercase, dot separated", spec.Name)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.instruments[spec.Name]; ok {
+		if existing.kind != kind || !reflect.DeepEqual(existing.spec, spec) {
+			return nil, fmt.Errorf("instrument %q already registered as %s with a different definition", spec.Name, existing.kind)
+		}
+		return existing.handle, nil
+	}
+	handle, err := create()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create instrument %q: %w", spec.Name, err)
+	}
+	r.instruments[spec.Name] = &registeredInstrument{kind: kind, spec: spec, handle: handle}
+	return handle, nil
+}
+
+// Instruments 返回已声明的全部指标定义
+func (r *MetricRegistry) Instruments() map[string]InstrumentSpec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	specs := make(map[string]InstrumentSpec, len(r.instruments))
+	for name, inst := range r.instruments {
+		specs[name] = inst.spec
+	}
+	return specs
+}
+
+func (r *MetricRegistry) Counter(spec InstrumentSpec) (*Counter, error) {
+	h, err := r.register(CounterKind, spec, func() (interface{}, error) {
+		c, err := r.meter.Int64Counter(spec.Name, metric.WithDescription(spec.Description), metric.WithUnit(spec.Unit))
+		return &Counter{instrument: r.newInstrument(spec), counter: c}, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h.(*Counter), nil
+}
+
+func (r *MetricRegistry) UpDownCounter(spec InstrumentSpec) (*UpDownCounter, error) {
+	h, err := r.register(UpDownCounterKind, spec, func() (interface{}, error) {
+		c, err := r.meter.Int64UpDownCounter(spec.Name, metric.WithDescription(spec.Description), metric.WithUnit(spec.Unit))
+		return &UpDownCounter{instrument: r.newInstrument(spec), counter: c}, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h.(*UpDownCounter), nil
+}
+
+func (r *MetricRegistry) Histogram(spec InstrumentSpec) (*Histogram, error) {
+	h, err := r.register(HistogramKind, spec, func() (interface{}, error) {
+		hist, err := r.meter.Float64Histogram(spec.Name, metric.WithDescription(spec.Description), metric.WithUnit(spec.Unit))
+		return &Histogram{instrument: r.newInstrument(spec), histogram: hist}, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h.(*Histogram), nil
+}
+
+func (r *MetricRegistry) Gauge(spec InstrumentSpec) (*Gauge, error) {
+	h, err := r.register(GaugeKind, spec, func() (interface{}, error) {
+		g, err := r.meter.Float64Gauge(spec.Name, metric.WithDescription(spec.Description), metric.WithUnit(spec.Unit))
+		return &Gauge{instrument: r.newInstrument(spec), gauge: g}, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h.(*Gauge), nil
+}
+
+func (r *MetricRegistry) ObservableCounter(spec InstrumentSpec, fn ObserveFunc) error {
+	_, err := r.register(ObservableCounterKind, spec, func() (interface{}, error) {
+		inst := r.newInstrument(spec)
+		return r.meter.Float64ObservableCounter(spec.Name, metric.WithDescription(spec.Description), metric.WithUnit(spec.Unit),
+			metric.WithFloat64Callback(inst.callback(fn)))
+	})
+	return err
+}
+
+func (r *MetricRegistry) ObservableUpDownCounter(spec InstrumentSpec, fn ObserveFunc) error {
+	_, err := r.register(ObservableUpDownCounterKind, spec, func() (interface{}, error) {
+		inst := r.newInstrument(spec)
+		return r.meter.Float64ObservableUpDownCounter(spec.Name, metric.WithDescription(spec.Description), metric.WithUnit(spec.Unit),
+			metric.WithFloat64Callback(inst.callback(fn)))
+	})
+	return err
+}
+
+func (r *MetricRegistry) ObservableGauge(spec InstrumentSpec, fn ObserveFunc) error {
+	_, err := r.register(ObservableGaugeKind, spec, func() (interface{}, error) {
+		inst := r.newInstrument(spec)
+		return r.meter.Float64ObservableGauge(spec.Name, metric.WithDescription(spec.Description), metric.WithUnit(spec.Unit),
+			metric.WithFloat64Callback(inst.callback(fn)))
+	})
+	return err
+}
+
+// instrument 是各类指标句柄共用的部分，负责按 AttributeKeys 过滤属性并限制属性组合数
+type instrument struct {
+	allowed map[attribute.Key]struct{}
+	limiter *cardinalityLimiter
+}
+
+// 在 register 的 create 中调用，此时已持有 r.mu
+func (r *MetricRegistry) newInstrument(spec InstrumentSpec) instrument {
+	limit := spec.CardinalityLimit
+	if limit == 0 {
+		limit = r.cardinalityLimit
+	}
+	var inst instrument
+	if limit > 0 {
+		inst.limiter = newCardinalityLimiter(spec.Name, limit)
+		r.limiters[spec.Name] = inst.limiter
+	}
+	if len(spec.AttributeKeys) == 0 {
+		return inst
+	}
+	inst.allowed = make(map[attribute.Key]struct{}, len(spec.AttributeKeys))
+	for _, k := range spec.AttributeKeys {
+		inst.allowed[k] = struct{}{}
+	}
+	return inst
+}
+
+func (i instrument) attributes(attrs []attribute.KeyValue) attribute.Set {
+	var set attribute.Set
+	if i.allowed == nil {
+		set = attribute.NewSet(attrs...)
+	} else {
+		set, _ = attribute.NewSetWithFiltered(attrs, func(kv attribute.KeyValue) bool {
+			_, ok := i.allowed[kv.Key]
+			return ok
+		})
+	}
+	return i.limiter.admit(set)
+}
+
+func (i instrument) callback(fn ObserveFunc) metric.Float64Callback {
+	return func(ctx context.Context, o metric.Float64Observer) error {
+		return fn(ctx, func(value float64, attrs ...attribute.KeyValue) {
+			o.Observe(value, metric.WithAttributeSet(i.attributes(attrs)))
+		})
+	}
+}
+
+// 同步指标的记录方法会在 ctx 没有 span 时从 goroutine 的 trace 栈取当前 span，
+// 使 SDK 生成的 exemplar 带上 trace_id/span_id
+
+// Counter 单调递增的整数计数器，nil 句柄的所有方法均为空操作
+type Counter struct {
+	instrument
+	counter metric.Int64Counter
+}
+
+func (c *Counter) Add(ctx context.Context, incr int64, attrs ...attribute.KeyValue) {
+	if c == nil {
+		return
+	}
+	c.counter.Add(EnsureSpanContext(ctx), incr, metric.WithAttributeSet(c.attributes(attrs)))
+}
+
+// UpDownCounter 可增可减的整数计数器
+type UpDownCounter struct {
+	instrument
+	counter metric.Int64UpDownCounter
+}
+
+func (c *UpDownCounter) Add(ctx context.Context, incr int64, attrs ...attribute.KeyValue) {
+	if c == nil {
+		return
+	}
+	c.counter.Add(EnsureSpanContext(ctx), incr, metric.WithAttributeSet(c.attributes(attrs)))
+}
+
+// Histogram 记录数值分布
+type Histogram struct {
+	instrument
+	histogram metric.Float64Histogram
+}
+
+func (h *Histogram) Record(ctx context.Context, value float64, attrs ...attribute.KeyValue) {
+	if h == nil {
+		return
+	}
+	h.histogram.Record(EnsureSpanContext(ctx), value, metric.WithAttributeSet(h.attributes(attrs)))
+}
+
+// Gauge 记录瞬时值
+type Gauge struct {
+	instrument
+	gauge metric.Float64Gauge
+}
+
+func (g *Gauge) Record(ctx context.Context, value float64, attrs ...attribute.KeyValue) {
+	if g == nil {
+		return
+	}
+	g.gauge.Record(EnsureSpanContext(ctx), value, metric.WithAttributeSet(g.attributes(attrs)))
+}
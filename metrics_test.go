@@ -0,0 +1,108 @@
+package probesdk
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"testing"
+)
+
+func newTestRegistry() (*MetricRegistry, *sdkmetric.ManualReader) {
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	return NewMetricRegistry(provider.Meter("test")), reader
+}
+
+func TestMetricRegistryValidation(t *testing.T) {
+	r, _ := newTestRegistry()
+
+	if _, err := r.Counter(InstrumentSpec{Name: "Orders Created"}); err == nil {
+		t.Errorf("expect invalid name error")
+	}
+
+	spec := InstrumentSpec{Name: "orders.created", Unit: "{order}"}
+	c1, err := r.Counter(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := r.Counter(spec)
+	if err != nil || c1 != c2 {
+		t.Errorf("expect same handle for identical spec, got %v", err)
+	}
+	if _, err := r.Histogram(spec); err == nil {
+		t.Errorf("expect error registering a different kind under the same name")
+	}
+	if _, err := r.Counter(InstrumentSpec{Name: "orders.created", Unit: "1"}); err == nil {
+		t.Errorf("expect error registering a different unit under the same name")
+	}
+}
+
+func TestMetricRegistryAttributeKeys(t *testing.T) {
+	r, reader := newTestRegistry()
+
+	h, err := r.Histogram(InstrumentSpec{
+		Name:          "queue.wait",
+		Unit:          "s",
+		AttributeKeys: []attribute.Key{"queue"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Record(context.Background(), 0.5, attribute.String("queue", "orders"), attribute.String("user.id", "42"))
+
+	m := collectMetric(t, reader, "queue.wait")
+	if m.Unit != "s" {
+		t.Errorf("expect unit s, got %s", m.Unit)
+	}
+	dp := m.Data.(metricdata.Histogram[float64]).DataPoints[0]
+	if dp.Attributes.Len() != 1 || !dp.Attributes.HasValue("queue") {
+		t.Errorf("expect only allowed attributes, got %v", dp.Attributes.ToSlice())
+	}
+}
+
+func TestMetricRegistryKinds(t *testing.T) {
+	r, reader := newTestRegistry()
+	ctx := context.Background()
+
+	upDown, err := r.UpDownCounter(InstrumentSpec{Name: "jobs.active"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	upDown.Add(ctx, 2)
+	upDown.Add(ctx, -1)
+
+	gauge, err := r.Gauge(InstrumentSpec{Name: "pool.utilization"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gauge.Record(ctx, 0.75)
+
+	err = r.ObservableGauge(InstrumentSpec{Name: "queue.length"}, func(ctx context.Context, observe func(float64, ...attribute.KeyValue)) error {
+		observe(3, attribute.String("queue", "orders"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v := collectMetric(t, reader, "jobs.active").Data.(metricdata.Sum[int64]).DataPoints[0].Value; v != 1 {
+		t.Errorf("expect up-down counter value 1, got %d", v)
+	}
+	if v := collectMetric(t, reader, "pool.utilization").Data.(metricdata.Gauge[float64]).DataPoints[0].Value; v != 0.75 {
+		t.Errorf("expect gauge value 0.75, got %v", v)
+	}
+	if v := collectMetric(t, reader, "queue.length").Data.(metricdata.Gauge[float64]).DataPoints[0].Value; v != 3 {
+		t.Errorf("expect observed value 3, got %v", v)
+	}
+	if len(r.Instruments()) != 3 {
+		t.Errorf("expect 3 registered instruments, got %d", len(r.Instruments()))
+	}
+}
+
+func TestNilHandles(t *testing.T) {
+	var c *Counter
+	var h *Histogram
+	c.Add(context.Background(), 1)
+	h.Record(context.Background(), 1)
+}
@@ -0,0 +1,330 @@
+package probesdk
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/google/pprof/profile"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+	"regexp"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OFFCPU_INTERVAL_ENV 设置后 init 启动 off-CPU 采样器，值为采样间隔，如 50ms。
+// 每次采样都会 stop-the-world 抓取全部 goroutine 栈，间隔不宜过小
+const OFFCPU_INTERVAL_ENV = "PROBESDK_OFFCPU_INTERVAL"
+
+// goroutine 状态的分类，作为 span 属性 probesdk.wallclock.<category> 的后缀
+const (
+	WallClockRunning = "running"
+	WallClockChannel = "channel"
+	WallClockLock    = "lock"
+	WallClockIO      = "io"
+	WallClockSyscall = "syscall"
+	WallClockSleep   = "sleep"
+	WallClockOther   = "other"
+)
+
+const wallClockAttributePrefix = "probesdk.wallclock."
+
+// 超过这么多次采样没有出现在任何 goroutine 栈上的 span 视为已结束，丢弃其统计
+const wallClockStaleTicks = 100
+
+// OffCPUSampler 定期抓取 goroutine 的状态和 trace 栈，将各状态的耗时计到栈顶 span 上，
+// span 结束时以 probesdk.wallclock.* 属性（单位秒）记录
+type OffCPUSampler struct {
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+
+	mu    sync.Mutex
+	tick  int64
+	spans map[trace.SpanID]*wallClock
+}
+
+type wallClock struct {
+	samples  int64
+	lastTick int64
+	byState  map[string]time.Duration
+}
+
+var offCPUSampler atomic.Pointer[OffCPUSampler]
+
+func NewOffCPUSampler(interval time.Duration) *OffCPUSampler {
+	return &OffCPUSampler{
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		spans:    make(map[trace.SpanID]*wallClock),
+	}
+}
+
+// Start 启动采样并设为全局采样器，EndSpan、HTTPHandler 和 gRPC 服务端结束 span 时读取它的统计
+func (s *OffCPUSampler) Start() {
+	offCPUSampler.Store(s)
+	go func() {
+		defer close(s.done)
+		// 清掉继承自创建者的标签，采样 goroutine 自身不计入任何 span
+		pprof.SetGoroutineLabels(context.Background())
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				s.Sample()
+			}
+		}
+	}()
+}
+
+func (s *OffCPUSampler) Shutdown(ctx context.Context) error {
+	s.once.Do(func() {
+		offCPUSampler.CompareAndSwap(s, nil)
+		close(s.stop)
+	})
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Sample 抓取一次 goroutine 快照，每个 goroutine 按当前状态给栈顶 span 计一个采样间隔
+func (s *OffCPUSampler) Sample() {
+	snapshot, err := snapshotGoroutines()
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tick++
+	for _, g := range snapshot {
+		wc, ok := s.spans[g.spanID]
+		if !ok {
+			wc = &wallClock{byState: map[string]time.Duration{}}
+			s.spans[g.spanID] = wc
+		}
+		wc.samples += g.count
+		wc.lastTick = s.tick
+		wc.byState[g.category] += time.Duration(g.count) * s.interval
+	}
+	for id, wc := range s.spans {
+		if s.tick-wc.lastTick > wallClockStaleTicks {
+			delete(s.spans, id)
+		}
+	}
+}
+
+// take 取出并删除 span 的统计
+func (s *OffCPUSampler) take(spanID trace.SpanID) *wallClock {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	wc := s.spans[spanID]
+	delete(s.spans, spanID)
+	return wc
+}
+
+// 在 span 结束前写入 wall-clock 分解，没有采样器或没有采样到时不写
+func recordWallClock(span trace.Span) {
+	s := offCPUSampler.Load()
+	if s == nil || !span.IsRecording() {
+		return
+	}
+	wc := s.take(span.SpanContext().SpanID())
+	if wc == nil {
+		return
+	}
+	attrs := []attribute.KeyValue{attribute.Int64(wallClockAttributePrefix+"samples", wc.samples)}
+	for state, d := range wc.byState {
+		attrs = append(attrs, attribute.Float64(wallClockAttributePrefix+state, d.Seconds()))
+	}
+	span.SetAttributes(attrs...)
+}
+
+type goroutineSample struct {
+	spanID   trace.SpanID
+	category string
+	count    int64
+}
+
+// 带标签的 goroutine profile 没有状态，文本 dump 有状态但没有标签，两者按去掉 runtime 帧后的调用栈关联
+func snapshotGoroutines() ([]goroutineSample, error) {
+	var pbuf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&pbuf, 0); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	p, err := profile.Parse(&pbuf)
+	if err != nil {
+		return nil, err
+	}
+	return attributeStates(p, parseGoroutineStates(buf)), nil
+}
+
+// 逐个 goroutine 分配状态：profile 中每个 goroutine 从同一调用栈的状态列表里取走一个，
+// 调用栈相同但属于不同 span 的 goroutine 不会都记成出现最多的状态。不属于任何 span 的 goroutine 也要取走自己的一份。
+// 两次抓取之间新建的 goroutine 在 dump 中可能没有对应项，记为 other
+func attributeStates(p *profile.Profile, states map[string][]string) []goroutineSample {
+	type key struct {
+		spanID   trace.SpanID
+		category string
+	}
+	counts := map[key]int64{}
+	var order []key
+	for _, sample := range p.Sample {
+		var frames []string
+		for _, loc := range sample.Location {
+			for _, line := range loc.Line {
+				if line.Function != nil {
+					frames = appendFrame(frames, line.Function.Name, line.Line)
+				}
+			}
+		}
+		stack := strings.Join(frames, "\n")
+		sc, traced := sampleSpanContext(sample)
+		for i := int64(0); i < sample.Value[0]; i++ {
+			category := WallClockOther
+			if remaining := states[stack]; len(remaining) > 0 {
+				category, states[stack] = remaining[0], remaining[1:]
+			}
+			if !traced {
+				continue
+			}
+			k := key{sc.SpanID(), category}
+			if _, ok := counts[k]; !ok {
+				order = append(order, k)
+			}
+			counts[k]++
+		}
+	}
+	result := make([]goroutineSample, len(order))
+	for i, k := range order {
+		result[i] = goroutineSample{spanID: k.spanID, category: k.category, count: counts[k]}
+	}
+	return result
+}
+
+func appendFrame(frames []string, function string, line int64) []string {
+	if strings.HasPrefix(function, "runtime.") {
+		return frames
+	}
+	return append(frames, function+":"+strconv.FormatInt(line, 10))
+}
+
+var goroutineHeaderRegexp = regexp.MustCompile(`^goroutine \d+ .*\[([^\]]*)\]:$`)
+
+// 解析 runtime.Stack(all) 的输出，返回调用栈到各 goroutine 状态分类的映射
+func parseGoroutineStates(dump []byte) map[string][]string {
+	states := map[string][]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(dump))
+	scanner.Buffer(make([]byte, 64*1024), len(dump)+1)
+
+	var state, function string
+	var frames []string
+	inGoroutine, created := false, false
+	flush := func() {
+		if inGoroutine {
+			key := strings.Join(frames, "\n")
+			states[key] = append(states[key], state)
+		}
+		inGoroutine, created, frames = false, false, nil
+	}
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			flush()
+		case goroutineHeaderRegexp.MatchString(line):
+			flush()
+			inGoroutine = true
+			state = stateCategory(goroutineHeaderRegexp.FindStringSubmatch(line)[1])
+		case !inGoroutine || created:
+		case strings.HasPrefix(line, "created by "):
+			created = true
+		case strings.HasPrefix(line, "\t"):
+			// \t/path/file.go:123 +0x1d
+			loc := strings.Fields(line)[0]
+			if i := strings.LastIndex(loc, ":"); i >= 0 && function != "" {
+				n, _ := strconv.ParseInt(loc[i+1:], 10, 64)
+				frames = appendFrame(frames, function, n)
+			}
+			function = ""
+		default:
+			// 去掉参数列表，如 main.(*T).m(0xc000010000, ...)
+			function = line
+			if strings.HasSuffix(line, ")") {
+				if i := strings.LastIndex(line, "("); i > 0 {
+					function = line[:i]
+				}
+			}
+		}
+	}
+	flush()
+	return states
+}
+
+// 将 goroutine 的等待原因归类，如 "chan receive, 2 minutes" 归为 channel
+func stateCategory(state string) string {
+	if i := strings.Index(state, ","); i >= 0 {
+		state = state[:i]
+	}
+	switch {
+	case state == "running" || state == "runnable":
+		return WallClockRunning
+	case strings.HasPrefix(state, "chan ") || strings.HasPrefix(state, "select"):
+		return WallClockChannel
+	case strings.HasPrefix(state, "sync.") || strings.HasPrefix(state, "semacquire"):
+		return WallClockLock
+	case state == "IO wait":
+		return WallClockIO
+	case state == "syscall":
+		return WallClockSyscall
+	case state == "sleep":
+		return WallClockSleep
+	}
+	return WallClockOther
+}
+
+// InitOffCPUSampler 按 OFFCPU_INTERVAL_ENV 启动 off-CPU 采样器
+func InitOffCPUSampler(ctx context.Context) (func(), error) {
+	v := os.Getenv(OFFCPU_INTERVAL_ENV)
+	if v == "" {
+		return func() {}, nil
+	}
+	interval, err := time.ParseDuration(v)
+	if err != nil || interval <= 0 {
+		return func() {}, fmt.Errorf("invalid %s %q", OFFCPU_INTERVAL_ENV, v)
+	}
+	sampler := NewOffCPUSampler(interval)
+	sampler.Start()
+	return func() {
+		cxt, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		if err := sampler.Shutdown(cxt); err != nil {
+			otel.Handle(err)
+		}
+	}, nil
+}
@@ -0,0 +1,143 @@
+package probesdk
+
+import (
+	"context"
+	"github.com/google/pprof/profile"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStateCategory(t *testing.T) {
+	cases := map[string]string{
+		"running":                   WallClockRunning,
+		"chan receive, 2 minutes":   WallClockChannel,
+		"select":                    WallClockChannel,
+		"sync.Mutex.Lock":           WallClockLock,
+		"semacquire":                WallClockLock,
+		"IO wait, 5 minutes":        WallClockIO,
+		"syscall, locked to thread": WallClockSyscall,
+		"sleep":                     WallClockSleep,
+		"GC worker (idle)":          WallClockOther,
+	}
+	for state, want := range cases {
+		if got := stateCategory(state); got != want {
+			t.Errorf("%q: expect %s, got %s", state, want, got)
+		}
+	}
+}
+
+func TestParseGoroutineStates(t *testing.T) {
+	dump := []byte(`goroutine 7 [chan receive, 1 minutes]:
+runtime.gopark(0x0?, 0x0?, 0x0?, 0x0?, 0x0?)
+	/usr/local/go/src/runtime/proc.go:424 +0xce fp=0xc000 sp=0xc000 pc=0x43
+main.(*worker).wait(0xc000010000)
+	/app/worker.go:42 +0x25
+main.run[...](...)
+	/app/main.go:10
+created by main.main in goroutine 1
+	/app/main.go:8 +0x1d
+
+goroutine 8 [running]:
+main.spin()
+	/app/main.go:20 +0x5
+`)
+	states := parseGoroutineStates(dump)
+	if got := states["main.(*worker).wait:42\nmain.run[...]:10"]; len(got) != 1 || got[0] != WallClockChannel {
+		t.Errorf("expect channel state for worker stack, got %v", states)
+	}
+	if got := states["main.spin:20"]; len(got) != 1 || got[0] != WallClockRunning {
+		t.Errorf("expect running state for spin, got %v", states)
+	}
+}
+
+// 调用栈相同、分属不同 span 的 goroutine 各自使用自己的状态
+func TestAttributeStatesPerGoroutine(t *testing.T) {
+	fn := &profile.Function{ID: 1, Name: "main.handle"}
+	loc := &profile.Location{ID: 1, Line: []profile.Line{{Function: fn, Line: 42}}}
+	sample := func(count int64, spanID string) *profile.Sample {
+		s := &profile.Sample{Location: []*profile.Location{loc}, Value: []int64{count}}
+		if spanID != "" {
+			s.Label = map[string][]string{ProfTraceIDLabel: {"4bf92f3577b34da6a3ce929d0e0e4736"}, ProfSpanIDLabel: {spanID}}
+		}
+		return s
+	}
+	p := &profile.Profile{Sample: []*profile.Sample{
+		sample(1, "00f067aa0ba902b7"),
+		sample(2, "00f067aa0ba902b8"),
+		sample(1, ""),
+	}}
+	states := map[string][]string{"main.handle:42": {WallClockLock, WallClockLock, WallClockRunning, WallClockRunning}}
+
+	got := map[string]int64{}
+	for _, g := range attributeStates(p, states) {
+		got[g.spanID.String()+"/"+g.category] += g.count
+	}
+	want := map[string]int64{
+		"00f067aa0ba902b7/" + WallClockLock:    1,
+		"00f067aa0ba902b8/" + WallClockLock:    1,
+		"00f067aa0ba902b8/" + WallClockRunning: 1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expect %v, got %v", want, got)
+	}
+}
+
+func TestOffCPUSampler(t *testing.T) {
+	sr := newSpanRecorder(t)
+	sampler := NewOffCPUSampler(10 * time.Millisecond)
+
+	var mu sync.Mutex
+	mu.Lock()
+	started := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		IsolateProfLabel()
+		_ = WithSpan("locked", func(ctx context.Context) error {
+			close(started)
+			mu.Lock()
+			return nil
+		})
+	}()
+	<-started
+	// 等待 goroutine 阻塞在 mu.Lock 上
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		sampler.Sample()
+	}
+	offCPUSampler.Store(sampler)
+	defer offCPUSampler.Store(nil)
+	mu.Unlock()
+	<-done
+
+	attrs := map[string]float64{}
+	for _, kv := range findSpan(t, sr, "locked").Attributes() {
+		attrs[string(kv.Key)] = kv.Value.AsFloat64()
+		if kv.Key == wallClockAttributePrefix+"samples" {
+			attrs[string(kv.Key)] = float64(kv.Value.AsInt64())
+		}
+	}
+	if attrs[wallClockAttributePrefix+"samples"] != 5 {
+		t.Errorf("expect 5 samples, got %v", attrs)
+	}
+	if attrs[wallClockAttributePrefix+WallClockLock] != 0.05 {
+		t.Errorf("expect 50ms blocked on lock, got %v", attrs)
+	}
+}
+
+func TestOffCPUSamplerLifecycle(t *testing.T) {
+	sampler := NewOffCPUSampler(5 * time.Millisecond)
+	sampler.Start()
+	if offCPUSampler.Load() != sampler {
+		t.Errorf("expect sampler registered globally")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := sampler.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if offCPUSampler.Load() != nil {
+		t.Errorf("expect sampler unregistered after shutdown")
+	}
+}
@@ -0,0 +1,23 @@
+// Package pprofhttp 在指定的 mux 上挂载 net/http/pprof 的接口和按 trace 分组的 goroutine dump。
+// 导入 net/http/pprof 会在 http.DefaultServeMux 上注册 /debug/pprof/，所以放在单独的包里，需要时再导入
+package pprofhttp
+
+import (
+	"github.com/gongyuan167/probesdk"
+	"net/http"
+	"net/http/pprof"
+)
+
+// Register 在 mux 上挂载 net/http/pprof 的各个接口和 probesdk.GOROUTINE_DUMP_PATH。
+// mux 为 http.DefaultServeMux 时 net/http/pprof 已经注册过，只挂载 goroutine dump
+func Register(mux *http.ServeMux) {
+	if mux != http.DefaultServeMux {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	// net/http/pprof 在 DefaultServeMux 上注册的是 "GET /debug/pprof/"，不带方法的更长路径会与之冲突
+	mux.Handle(http.MethodGet+" "+probesdk.GOROUTINE_DUMP_PATH, probesdk.GoroutineDumpHandler())
+}
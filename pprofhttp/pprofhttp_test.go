@@ -0,0 +1,34 @@
+package pprofhttp
+
+import (
+	"github.com/gongyuan167/probesdk"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func get(t *testing.T, h http.Handler, path string) int {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec.Code
+}
+
+func TestRegister(t *testing.T) {
+	mux := http.NewServeMux()
+	Register(mux)
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/cmdline", probesdk.GOROUTINE_DUMP_PATH} {
+		if code := get(t, mux, path); code != http.StatusOK {
+			t.Errorf("expect %s mounted, got %d", path, code)
+		}
+	}
+}
+
+// net/http/pprof 已在 DefaultServeMux 上注册，再次挂载不能因路径冲突 panic
+func TestRegisterDefaultServeMux(t *testing.T) {
+	Register(http.DefaultServeMux)
+	for _, path := range []string{"/debug/pprof/", probesdk.GOROUTINE_DUMP_PATH} {
+		if code := get(t, http.DefaultServeMux, path); code != http.StatusOK {
+			t.Errorf("expect %s mounted, got %d", path, code)
+		}
+	}
+}
@@ -0,0 +1,475 @@
+package probesdk
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/pprof/profile"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 持续 profiling 的环境变量，PROFILER_ENV 为 true 时 init 启动后台 profiler
+const (
+	PROFILER_ENV                = "PROBESDK_PROFILER"
+	PROFILER_ENDPOINT_ENV       = "PROBESDK_PROFILER_ENDPOINT"
+	PROFILER_DIR_ENV            = "PROBESDK_PROFILER_DIR"
+	PROFILER_INTERVAL_ENV       = "PROBESDK_PROFILER_INTERVAL"
+	PROFILER_CPU_DURATION_ENV   = "PROBESDK_PROFILER_CPU_DURATION"
+	PROFILER_TYPES_ENV          = "PROBESDK_PROFILER_TYPES"
+	PROFILER_MUTEX_FRACTION_ENV = "PROBESDK_PROFILER_MUTEX_FRACTION"
+	PROFILER_BLOCK_RATE_ENV     = "PROBESDK_PROFILER_BLOCK_RATE"
+)
+
+// ProfileType 采集的 profile 类型
+type ProfileType string
+
+const (
+	CPUProfile       ProfileType = "cpu"
+	HeapProfile      ProfileType = "heap"
+	GoroutineProfile ProfileType = "goroutine"
+	MutexProfile     ProfileType = "mutex"
+	BlockProfile     ProfileType = "block"
+)
+
+// ProfilerConfig 后台 profiler 的配置，Endpoint 和 Dir 至少设置一个
+type ProfilerConfig struct {
+	// Interval 两次采集的间隔，默认 60s
+	Interval time.Duration
+	// CPUDuration 每次 CPU profile 的采集时长，默认 10s，不能超过 Interval
+	CPUDuration time.Duration
+	// Types 默认采集 cpu、heap、goroutine
+	Types []ProfileType
+	// MutexFraction 大于 0 时设置 runtime 的 mutex 采样率，关闭 profiler 时恢复原值
+	MutexFraction int
+	// BlockRate 大于 0 时由 profiler 接管 block 采样率：启动时设置，关闭时置 0。
+	// runtime 读不到原来的采样率，应用自己也设置了 block 采样率时不要配置这一项
+	BlockRate int
+	// Endpoint 兼容 Pyroscope /ingest 的上传地址，每个 profile 以 multipart 的 profile 字段上传
+	Endpoint string
+	Headers  map[string]string
+	// Dir 本地目录，最多保留 MaxFiles 个文件（默认 100），超出时删除最旧的
+	Dir      string
+	MaxFiles int
+}
+
+// Profiler 定期采集 pprof profile 并打上资源属性后导出
+type Profiler struct {
+	cfg    ProfilerConfig
+	labels map[string]string
+	client *http.Client
+	// 导出使用的 ctx，Shutdown 超时时取消，中断进行中的上传
+	ctx    context.Context
+	cancel context.CancelFunc
+	stop   chan struct{}
+	done   chan struct{}
+	once   sync.Once
+	// 累计型 profile 上一次采集的结果和时间，只在采集 goroutine 中访问
+	prev     map[ProfileType]*profile.Profile
+	prevTime map[ProfileType]time.Time
+}
+
+// 进程启动时间，累计型 profile 第一次导出的区间起点
+var processStart = time.Now()
+
+func NewProfiler(cfg ProfilerConfig, res *resource.Resource) (*Profiler, error) {
+	if cfg.Endpoint == "" && cfg.Dir == "" {
+		return nil, fmt.Errorf("profiler: endpoint or dir is required")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Minute
+	}
+	if cfg.CPUDuration <= 0 {
+		cfg.CPUDuration = 10 * time.Second
+	}
+	if cfg.CPUDuration > cfg.Interval {
+		return nil, fmt.Errorf("profiler: cpu duration %s exceeds interval %s", cfg.CPUDuration, cfg.Interval)
+	}
+	if len(cfg.Types) == 0 {
+		cfg.Types = []ProfileType{CPUProfile, HeapProfile, GoroutineProfile}
+	}
+	for _, t := range cfg.Types {
+		switch t {
+		case CPUProfile, HeapProfile, GoroutineProfile, MutexProfile, BlockProfile:
+		default:
+			return nil, fmt.Errorf("profiler: unknown profile type %q", t)
+		}
+	}
+	if cfg.MaxFiles <= 0 {
+		cfg.MaxFiles = 100
+	}
+	if cfg.Dir != "" {
+		if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+
+	labels := map[string]string{}
+	if res != nil {
+		for _, kv := range res.Attributes() {
+			labels[string(kv.Key)] = kv.Value.Emit()
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Profiler{
+		cfg:      cfg,
+		labels:   labels,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		ctx:      ctx,
+		cancel:   cancel,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		prev:     make(map[ProfileType]*profile.Profile),
+		prevTime: make(map[ProfileType]time.Time),
+	}, nil
+}
+
+// Start 启动后台采集，需要调用 Shutdown 停止
+func (p *Profiler) Start() {
+	prevMutex := -1
+	if p.cfg.MutexFraction > 0 {
+		prevMutex = runtime.SetMutexProfileFraction(p.cfg.MutexFraction)
+	}
+	if p.cfg.BlockRate > 0 {
+		runtime.SetBlockProfileRate(p.cfg.BlockRate)
+	}
+	go func() {
+		defer close(p.done)
+		defer func() {
+			if prevMutex >= 0 {
+				runtime.SetMutexProfileFraction(prevMutex)
+			}
+			// 原来的 block 采样率读不到，只能关掉，见 ProfilerConfig.BlockRate
+			if p.cfg.BlockRate > 0 {
+				runtime.SetBlockProfileRate(0)
+			}
+		}()
+		ticker := time.NewTicker(p.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			p.collectAndExport()
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Shutdown 停止采集，正在进行的 CPU 采集会提前结束并导出，ctx 结束时取消未完成的上传
+func (p *Profiler) Shutdown(ctx context.Context) error {
+	p.once.Do(func() { close(p.stop) })
+	defer p.cancel()
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Profiler) collectAndExport() {
+	for _, t := range p.cfg.Types {
+		start := time.Now()
+		data, err := p.collect(t)
+		end := time.Now()
+		if err == nil && isCumulativeProfile(t) {
+			start = p.prevTime[t]
+			if start.IsZero() {
+				start = processStart
+			}
+			data, err = p.delta(t, data)
+			p.prevTime[t] = end
+		}
+		if err == nil {
+			err = p.Export(p.ctx, t, start, end, data)
+		}
+		if err != nil {
+			otel.Handle(fmt.Errorf("profiler: %s: %w", t, err))
+		}
+	}
+}
+
+func (p *Profiler) collect(t ProfileType) ([]byte, error) {
+	var buf bytes.Buffer
+	if t == CPUProfile {
+		if err := pprof.StartCPUProfile(&buf); err != nil {
+			return nil, err
+		}
+		timer := time.NewTimer(p.cfg.CPUDuration)
+		select {
+		case <-timer.C:
+		case <-p.stop:
+			timer.Stop()
+		}
+		pprof.StopCPUProfile()
+		return buf.Bytes(), nil
+	}
+	prof := pprof.Lookup(string(t))
+	if prof == nil {
+		return nil, fmt.Errorf("profile %s not found", t)
+	}
+	if err := prof.WriteTo(&buf, 0); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// mutex、block 和 heap 的 alloc_* 样本是进程启动以来的累计值
+func isCumulativeProfile(t ProfileType) bool {
+	return t == HeapProfile || t == MutexProfile || t == BlockProfile
+}
+
+// 减去上一次采集的累计值，导出的 profile 只包含两次采集之间的数据，第一次导出的是进程启动以来的数据。
+// heap 的 inuse_* 是采集时刻的快照，保持原值
+func (p *Profiler) delta(t ProfileType, data []byte) ([]byte, error) {
+	cur, err := profile.ParseData(data)
+	if err != nil {
+		return nil, err
+	}
+	prev := p.prev[t]
+	p.prev[t] = cur.Copy()
+	if prev == nil {
+		return data, nil
+	}
+	cumulative := make([]bool, len(prev.SampleType))
+	for i, st := range prev.SampleType {
+		cumulative[i] = t != HeapProfile || strings.HasPrefix(st.Type, "alloc_")
+	}
+	for _, s := range prev.Sample {
+		for i := range s.Value {
+			if cumulative[i] {
+				s.Value[i] = -s.Value[i]
+			} else {
+				s.Value[i] = 0
+			}
+		}
+	}
+	merged, err := profile.Merge([]*profile.Profile{cur, prev})
+	if err != nil {
+		return nil, err
+	}
+	// 区间内没有变化的调用栈合并后全为 0，去掉
+	samples := merged.Sample[:0]
+	for _, s := range merged.Sample {
+		for _, v := range s.Value {
+			if v != 0 {
+				samples = append(samples, s)
+				break
+			}
+		}
+	}
+	merged.Sample = samples
+	merged.TimeNanos = cur.TimeNanos
+	var buf bytes.Buffer
+	if err := merged.Write(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Export 为 profile 打上资源属性并写入配置的目的地
+func (p *Profiler) Export(ctx context.Context, t ProfileType, start, end time.Time, data []byte) error {
+	data, err := p.tag(data)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	if p.cfg.Dir != "" {
+		errs = append(errs, p.writeFile(t, end, data))
+	}
+	if p.cfg.Endpoint != "" {
+		errs = append(errs, p.upload(ctx, t, start, end, data))
+	}
+	return errors.Join(errs...)
+}
+
+// 将资源属性写入 profile 的 comments，本地文件也能追溯来源
+func (p *Profiler) tag(data []byte) ([]byte, error) {
+	prof, err := profile.ParseData(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range p.sortedLabelKeys() {
+		prof.Comments = append(prof.Comments, k+"="+p.labels[k])
+	}
+	var buf bytes.Buffer
+	if err := prof.Write(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (p *Profiler) sortedLabelKeys() []string {
+	keys := make([]string, 0, len(p.labels))
+	for k := range p.labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (p *Profiler) writeFile(t ProfileType, end time.Time, data []byte) error {
+	name := filepath.Join(p.cfg.Dir, fmt.Sprintf("%s-%d.pb.gz", t, end.UnixNano()))
+	if err := os.WriteFile(name, data, 0o644); err != nil {
+		return err
+	}
+	return p.rotate()
+}
+
+// 按文件名中的时间戳删除最旧的文件
+func (p *Profiler) rotate() error {
+	files, err := filepath.Glob(filepath.Join(p.cfg.Dir, "*-*.pb.gz"))
+	if err != nil || len(files) <= p.cfg.MaxFiles {
+		return err
+	}
+	timestamp := func(name string) int64 {
+		base := strings.TrimSuffix(filepath.Base(name), ".pb.gz")
+		ts, _ := strconv.ParseInt(base[strings.LastIndex(base, "-")+1:], 10, 64)
+		return ts
+	}
+	sort.Slice(files, func(i, j int) bool { return timestamp(files[i]) < timestamp(files[j]) })
+	for _, f := range files[:len(files)-p.cfg.MaxFiles] {
+		if err := os.Remove(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 按 Pyroscope 的 ingest 接口上传：name=<service>.<type>{labels}&from=&until=&format=pprof
+func (p *Profiler) upload(ctx context.Context, t ProfileType, start, end time.Time, data []byte) error {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("profile", "profile.pprof")
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	u, err := url.Parse(p.cfg.Endpoint)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	q.Set("name", p.appName(t))
+	q.Set("from", strconv.FormatInt(start.Unix(), 10))
+	q.Set("until", strconv.FormatInt(end.Unix(), 10))
+	q.Set("format", "pprof")
+	q.Set("spyName", "gospy")
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	for k, v := range p.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("upload failed: %s", resp.Status)
+	}
+	return nil
+}
+
+// Pyroscope 的标签名只允许字母、数字和下划线
+func (p *Profiler) appName(t ProfileType) string {
+	service := p.labels[string(semconv.ServiceNameKey)]
+	if service == "" {
+		service = "unknown_service"
+	}
+	var tags []string
+	for _, k := range p.sortedLabelKeys() {
+		if k == string(semconv.ServiceNameKey) {
+			continue
+		}
+		tags = append(tags, strings.ReplaceAll(k, ".", "_")+"="+p.labels[k])
+	}
+	return service + "." + string(t) + "{" + strings.Join(tags, ",") + "}"
+}
+
+// 从环境变量读取配置，未开启时返回 nil
+func profilerConfigFromEnv() (*ProfilerConfig, error) {
+	if enabled, _ := strconv.ParseBool(os.Getenv(PROFILER_ENV)); !enabled {
+		return nil, nil
+	}
+	cfg := &ProfilerConfig{
+		Endpoint: os.Getenv(PROFILER_ENDPOINT_ENV),
+		Dir:      os.Getenv(PROFILER_DIR_ENV),
+	}
+	var err error
+	if v := os.Getenv(PROFILER_INTERVAL_ENV); v != "" {
+		if cfg.Interval, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", PROFILER_INTERVAL_ENV, err)
+		}
+	}
+	if v := os.Getenv(PROFILER_CPU_DURATION_ENV); v != "" {
+		if cfg.CPUDuration, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", PROFILER_CPU_DURATION_ENV, err)
+		}
+	}
+	if v := os.Getenv(PROFILER_TYPES_ENV); v != "" {
+		for _, t := range strings.Split(v, ",") {
+			cfg.Types = append(cfg.Types, ProfileType(strings.TrimSpace(t)))
+		}
+	}
+	if v := os.Getenv(PROFILER_MUTEX_FRACTION_ENV); v != "" {
+		if cfg.MutexFraction, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", PROFILER_MUTEX_FRACTION_ENV, err)
+		}
+	}
+	if v := os.Getenv(PROFILER_BLOCK_RATE_ENV); v != "" {
+		if cfg.BlockRate, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", PROFILER_BLOCK_RATE_ENV, err)
+		}
+	}
+	return cfg, nil
+}
+
+// InitProfiler 按环境变量启动后台 profiler，返回的关闭方法与 trace 流水线一起在 Shutdown 中调用
+func InitProfiler(ctx context.Context, otelResource *resource.Resource) (func(), error) {
+	cfg, err := profilerConfigFromEnv()
+	if err != nil || cfg == nil {
+		return func() {}, err
+	}
+	profiler, err := NewProfiler(*cfg, otelResource)
+	if err != nil {
+		return func() {}, err
+	}
+	profiler.Start()
+	return func() {
+		cxt, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		if err := profiler.Shutdown(cxt); err != nil {
+			otel.Handle(err)
+		}
+	}, nil
+}
@@ -0,0 +1,272 @@
+package probesdk
+
+import (
+	"bytes"
+	"context"
+	"github.com/google/pprof/profile"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testProfilerResource() *resource.Resource {
+	return resource.NewSchemaless(semconv.ServiceName("checkout"), semconv.HostName("node-1"))
+}
+
+func TestProfilerDir(t *testing.T) {
+	dir := t.TempDir()
+	p, err := NewProfiler(ProfilerConfig{
+		Dir:      dir,
+		Types:    []ProfileType{HeapProfile, GoroutineProfile},
+		MaxFiles: 3,
+	}, testProfilerResource())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		p.collectAndExport()
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.pb.gz"))
+	if len(files) != 3 {
+		t.Fatalf("expect 3 files after rotation, got %d", len(files))
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	prof, err := profile.Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(prof.Comments, "service.name=checkout") || !slices.Contains(prof.Comments, "host.name=node-1") {
+		t.Errorf("expect resource attributes in comments, got %v", prof.Comments)
+	}
+}
+
+// 采集 CPU 和 goroutine profile 时其他 goroutine 在压栈和出栈，go test -race 下不应有数据竞争
+func TestProfilerConcurrentSpans(t *testing.T) {
+	newSpanRecorder(t)
+	enableProfilingLabels(t, ProfilingLabelsIDs)
+	p, err := NewProfiler(ProfilerConfig{
+		Dir:         t.TempDir(),
+		Types:       []ProfileType{CPUProfile, GoroutineProfile},
+		CPUDuration: 200 * time.Millisecond,
+	}, testProfilerResource())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				WithSpan("busy", func(ctx context.Context) error { return nil })
+			}
+		}
+	}()
+	p.collectAndExport()
+	close(stop)
+	<-done
+}
+
+func TestProfilerUpload(t *testing.T) {
+	type upload struct {
+		name string
+		prof *profile.Profile
+	}
+	uploads := make(chan upload, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, _, err := r.FormFile("profile")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prof, err := profile.Parse(file)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		uploads <- upload{name: r.URL.Query().Get("name"), prof: prof}
+	}))
+	defer srv.Close()
+
+	p, err := NewProfiler(ProfilerConfig{
+		Endpoint:    srv.URL + "/ingest",
+		Types:       []ProfileType{CPUProfile, HeapProfile},
+		Interval:    time.Hour,
+		CPUDuration: 50 * time.Millisecond,
+	}, testProfilerResource())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Start()
+
+	var names []string
+	for i := 0; i < 2; i++ {
+		select {
+		case u := <-uploads:
+			names = append(names, u.name)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for upload")
+		}
+	}
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if names[0] != "checkout.cpu{host_name=node-1}" || !strings.HasPrefix(names[1], "checkout.heap{") {
+		t.Errorf("unexpected app names %v", names)
+	}
+}
+
+func TestProfilerShutdownInterruptsCPU(t *testing.T) {
+	p, err := NewProfiler(ProfilerConfig{
+		Dir:         t.TempDir(),
+		Types:       []ProfileType{CPUProfile},
+		Interval:    time.Hour,
+		CPUDuration: time.Hour,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Start()
+	time.Sleep(20 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != nil {
+		t.Fatalf("expect shutdown to interrupt cpu profile, got %v", err)
+	}
+}
+
+func TestProfilerConfigValidation(t *testing.T) {
+	invalid := []ProfilerConfig{
+		{},
+		{Dir: t.TempDir(), Interval: time.Second, CPUDuration: time.Minute},
+		{Dir: t.TempDir(), Types: []ProfileType{"threadcreate"}},
+	}
+	for _, cfg := range invalid {
+		if _, err := NewProfiler(cfg, nil); err == nil {
+			t.Errorf("expect error for %+v", cfg)
+		}
+	}
+
+	t.Setenv(PROFILER_ENV, "true")
+	t.Setenv(PROFILER_TYPES_ENV, "cpu, mutex")
+	t.Setenv(PROFILER_INTERVAL_ENV, "30s")
+	cfg, err := profilerConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Interval != 30*time.Second || !slices.Equal(cfg.Types, []ProfileType{CPUProfile, MutexProfile}) {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+}
+
+func testCumulativeProfile(sampleTypes []string, values ...[]int64) []byte {
+	fn := &profile.Function{ID: 1, Name: "main.work"}
+	prof := &profile.Profile{Function: []*profile.Function{fn}}
+	for _, st := range sampleTypes {
+		prof.SampleType = append(prof.SampleType, &profile.ValueType{Type: st, Unit: "count"})
+	}
+	for i, v := range values {
+		loc := &profile.Location{ID: uint64(i + 1), Address: uint64(i + 1), Line: []profile.Line{{Function: fn, Line: int64(i + 1)}}}
+		prof.Location = append(prof.Location, loc)
+		prof.Sample = append(prof.Sample, &profile.Sample{Location: []*profile.Location{loc}, Value: v})
+	}
+	var buf bytes.Buffer
+	prof.Write(&buf)
+	return buf.Bytes()
+}
+
+func sampleValues(t *testing.T, data []byte) [][]int64 {
+	prof, err := profile.ParseData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values [][]int64
+	for _, s := range prof.Sample {
+		values = append(values, s.Value)
+	}
+	slices.SortFunc(values, func(a, b []int64) int { return slices.Compare(a, b) })
+	return values
+}
+
+func TestProfilerDelta(t *testing.T) {
+	p, err := NewProfiler(ProfilerConfig{Dir: t.TempDir()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mutex := []string{"contentions", "delay"}
+	first, err := p.delta(MutexProfile, testCumulativeProfile(mutex, []int64{2, 100}, []int64{1, 10}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sampleValues(t, first); !reflect.DeepEqual(got, [][]int64{{1, 10}, {2, 100}}) {
+		t.Errorf("expect first export to be cumulative, got %v", got)
+	}
+	// 第二个调用栈没有新的竞争，不应出现在结果中
+	second, err := p.delta(MutexProfile, testCumulativeProfile(mutex, []int64{5, 160}, []int64{1, 10}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sampleValues(t, second); !reflect.DeepEqual(got, [][]int64{{3, 60}}) {
+		t.Errorf("expect delta since last collection, got %v", got)
+	}
+
+	// heap 只对 alloc_* 做差，inuse_* 保持快照值
+	heap := []string{"alloc_objects", "alloc_space", "inuse_objects", "inuse_space"}
+	p.delta(HeapProfile, testCumulativeProfile(heap, []int64{10, 1000, 4, 400}))
+	data, err := p.delta(HeapProfile, testCumulativeProfile(heap, []int64{15, 1500, 3, 300}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sampleValues(t, data); !reflect.DeepEqual(got, [][]int64{{5, 500, 3, 300}}) {
+		t.Errorf("expect alloc delta and inuse snapshot, got %v", got)
+	}
+}
+
+func TestProfilerShutdownCancelsUpload(t *testing.T) {
+	canceled := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 读完请求体后服务端才能感知客户端断开
+		io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+		close(canceled)
+	}))
+	defer srv.Close()
+
+	p, err := NewProfiler(ProfilerConfig{
+		Endpoint: srv.URL + "/ingest",
+		Types:    []ProfileType{GoroutineProfile},
+		Interval: time.Hour,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Start()
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := p.Shutdown(ctx); err == nil {
+		t.Errorf("expect shutdown timeout while upload is blocked")
+	}
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expect upload canceled after shutdown")
+	}
+}
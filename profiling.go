@@ -0,0 +1,188 @@
+package probesdk
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/gongyuan167/probesdk/labels"
+	"github.com/google/pprof/profile"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// PROFILING_LABELS_ENV 控制是否在 goroutine 标签上写入可读的 span 标识：off（默认）、ids、names
+const PROFILING_LABELS_ENV = "PROBESDK_PROFILING_LABELS"
+
+// 开启 profiling 标签后栈顶 span 对应的 pprof 标签
+const (
+	ProfTraceIDLabel  = labels.ProfTraceIDLabel
+	ProfSpanIDLabel   = labels.ProfSpanIDLabel
+	ProfSpanNameLabel = labels.ProfSpanNameLabel
+	// ProfTraceStackLabel 由 DecodeProfileLabels 写入，按栈底到栈顶列出 span_id，以 > 分隔
+	ProfTraceStackLabel = labels.ProfTraceStackLabel
+)
+
+// ProfilingLabelMode 决定 CPU profile 样本上带哪些可读标签
+type ProfilingLabelMode int32
+
+const (
+	ProfilingLabelsOff   ProfilingLabelMode = iota
+	ProfilingLabelsIDs                      // trace_id、span_id
+	ProfilingLabelsNames                    // 额外带上 span_name
+)
+
+var profilingLabelMode atomic.Int32
+
+// SetProfilingLabels 设置 profiling 标签模式，只影响之后入栈的 span
+func SetProfilingLabels(mode ProfilingLabelMode) {
+	profilingLabelMode.Store(int32(mode))
+}
+
+func ParseProfilingLabelMode(s string) (ProfilingLabelMode, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "off":
+		return ProfilingLabelsOff, nil
+	case "ids":
+		return ProfilingLabelsIDs, nil
+	case "names":
+		return ProfilingLabelsNames, nil
+	}
+	return ProfilingLabelsOff, fmt.Errorf("unknown profiling label mode %q", s)
+}
+
+func initProfilingLabels() error {
+	mode, err := ParseProfilingLabelMode(os.Getenv(PROFILING_LABELS_ENV))
+	if err != nil {
+		return err
+	}
+	SetProfilingLabels(mode)
+	return nil
+}
+
+// 将栈顶 span 写入可读标签，span 为 nil 时只写 ID
+func setSpanLabels(data map[string]string, sc trace.SpanContext, span trace.Span) {
+	mode := ProfilingLabelMode(profilingLabelMode.Load())
+	if mode == ProfilingLabelsOff {
+		return
+	}
+	data[ProfTraceIDLabel] = sc.TraceID().String()
+	data[ProfSpanIDLabel] = sc.SpanID().String()
+	delete(data, ProfSpanNameLabel)
+	if mode == ProfilingLabelsNames {
+		if named, ok := span.(interface{ Name() string }); ok {
+			data[ProfSpanNameLabel] = named.Name()
+		}
+	}
+}
+
+// 出栈后把可读标签恢复为新的栈顶，栈为空时删除
+func restoreSpanLabels(data map[string]string, size int) {
+	if _, ok := data[ProfSpanIDLabel]; !ok && ProfilingLabelMode(profilingLabelMode.Load()) == ProfilingLabelsOff {
+		return
+	}
+	if size > 0 {
+		// 栈顶编码损坏时按空栈处理，不能让出栈 panic
+		if sc, err := ParseTraceContext(data[getTargetKey(size-1)]); err == nil {
+			span, _ := stackSpan(data, size-1)
+			setSpanLabels(data, sc, span)
+			return
+		}
+	}
+	delete(data, ProfTraceIDLabel)
+	delete(data, ProfSpanIDLabel)
+	delete(data, ProfSpanNameLabel)
+}
+
+// ProfileGrouping 拆分 CPU profile 的维度
+type ProfileGrouping int
+
+const (
+	GroupBySpan ProfileGrouping = iota
+	GroupByTrace
+)
+
+// CaptureSpanProfiles 采集 d 时长（ctx 取消时提前结束）的 CPU profile，并按 span 或 trace 拆分为多个 profile，
+// key 为 span_id 或 trace_id 的十六进制字符串，不属于任何 span 的样本被丢弃
+func CaptureSpanProfiles(ctx context.Context, d time.Duration, by ProfileGrouping) (map[string]*profile.Profile, error) {
+	var buf bytes.Buffer
+	if err := pprof.StartCPUProfile(&buf); err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(d)
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		timer.Stop()
+	}
+	pprof.StopCPUProfile()
+
+	p, err := profile.Parse(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return SplitProfile(p, by), nil
+}
+
+// SplitProfile 按样本的 span 标签拆分 profile。优先使用可读标签，
+// 没有开启 profiling 标签时从 trace 栈的编码标签中解出栈顶 span
+func SplitProfile(p *profile.Profile, by ProfileGrouping) map[string]*profile.Profile {
+	groups := map[string][]int{}
+	for i, s := range p.Sample {
+		sc, ok := sampleSpanContext(s)
+		if !ok {
+			continue
+		}
+		key := sc.SpanID().String()
+		if by == GroupByTrace {
+			key = sc.TraceID().String()
+		}
+		groups[key] = append(groups[key], i)
+	}
+
+	result := make(map[string]*profile.Profile, len(groups))
+	for key, indexes := range groups {
+		// Copy 保持样本顺序，按下标取出该组的样本
+		sub := p.Copy()
+		samples := make([]*profile.Sample, len(indexes))
+		for i, idx := range indexes {
+			samples[i] = sub.Sample[idx]
+		}
+		sub.Sample = samples
+		result[key] = sub.Compact()
+	}
+	return result
+}
+
+func sampleSpanContext(s *profile.Sample) (trace.SpanContext, bool) {
+	if ids := s.Label[ProfSpanIDLabel]; len(ids) > 0 {
+		traceID, err1 := trace.TraceIDFromHex(first(s.Label[ProfTraceIDLabel]))
+		spanID, err2 := trace.SpanIDFromHex(ids[0])
+		if err1 == nil && err2 == nil {
+			return trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID}), true
+		}
+	}
+	size, err := strconv.Atoi(first(s.Label[GRTTraceContextLen]))
+	if err != nil || size == 0 {
+		return trace.SpanContext{}, false
+	}
+	sc, err := ParseTraceContext(first(s.Label[getTargetKey(size-1)]))
+	return sc, err == nil && sc.IsValid()
+}
+
+// DecodeProfileLabels 将样本上 trace 栈的编码标签改写为可读的 trace_id/span_id（栈顶 span）和 trace_stack 标签，
+// 并删除编码标签，改写后的 profile 可直接用 go tool pprof -tagfocus 等按 trace 过滤
+func DecodeProfileLabels(p *profile.Profile) {
+	labels.DecodeProfileLabels(p)
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
@@ -0,0 +1,170 @@
+package probesdk
+
+import (
+	"context"
+	"github.com/google/pprof/profile"
+	"reflect"
+	"runtime/pprof"
+	"testing"
+	"time"
+)
+
+func enableProfilingLabels(t *testing.T, mode ProfilingLabelMode) {
+	SetProfilingLabels(mode)
+	t.Cleanup(func() { SetProfilingLabels(ProfilingLabelsOff) })
+}
+
+func TestProfilingLabels(t *testing.T) {
+	newSpanRecorder(t)
+	enableProfilingLabels(t, ProfilingLabelsNames)
+	IsolateProfLabel()
+
+	var err error
+	_, outer := StartSpan(context.Background(), "outer")
+	labels := GetProfLabel()
+	if labels[ProfTraceIDLabel] != outer.SpanContext().TraceID().String() || labels[ProfSpanNameLabel] != "outer" {
+		t.Errorf("expect outer span labels, got %v", labels)
+	}
+
+	_, inner := StartSpan(context.Background(), "inner")
+	labels = GetProfLabel()
+	if labels[ProfSpanIDLabel] != inner.SpanContext().SpanID().String() || labels[ProfSpanNameLabel] != "inner" {
+		t.Errorf("expect inner span labels, got %v", labels)
+	}
+	EndSpan(&err)
+	labels = GetProfLabel()
+	if labels[ProfSpanIDLabel] != outer.SpanContext().SpanID().String() || labels[ProfSpanNameLabel] != "outer" {
+		t.Errorf("expect labels restored to outer span, got %v", labels)
+	}
+	EndSpan(&err)
+	if labels = GetProfLabel(); labels[ProfSpanIDLabel] != "" {
+		t.Errorf("expect labels removed on empty stack, got %v", labels)
+	}
+}
+
+// 可读标签写在压栈时换上的新 map 中，已经继承了 map 的子 goroutine 看不到，出栈后换回原来的 map
+func TestProfilingLabelsNotSharedWithChildren(t *testing.T) {
+	newSpanRecorder(t)
+	enableProfilingLabels(t, ProfilingLabelsIDs)
+	pprof.SetGoroutineLabels(pprof.WithLabels(context.Background(), pprof.Labels("region", "bj")))
+	defer pprof.SetGoroutineLabels(context.Background())
+	before := GetProfLabel()
+
+	child := make(chan map[string]string)
+	release := make(chan struct{})
+	go func() {
+		child <- GetProfLabel()
+		<-release
+	}()
+	inherited := <-child
+	WithSpan("span", func(ctx context.Context) error {
+		if _, ok := inherited[ProfSpanIDLabel]; ok {
+			t.Errorf("expect child labels untouched, got %v", inherited)
+		}
+		return nil
+	})
+	close(release)
+	if after := GetProfLabel(); len(after) != 1 || reflect.ValueOf(after).Pointer() != reflect.ValueOf(before).Pointer() {
+		t.Errorf("expect previous label map restored, got %v", after)
+	}
+}
+
+func TestParseProfilingLabelMode(t *testing.T) {
+	for s, want := range map[string]ProfilingLabelMode{"": ProfilingLabelsOff, "ids": ProfilingLabelsIDs, "Names": ProfilingLabelsNames} {
+		if got, err := ParseProfilingLabelMode(s); err != nil || got != want {
+			t.Errorf("%q: expect %v, got %v %v", s, want, got, err)
+		}
+	}
+	if _, err := ParseProfilingLabelMode("all"); err == nil {
+		t.Errorf("expect error for unknown mode")
+	}
+}
+
+// Compact 会合并相同的样本，按值求和
+func sampleCount(p *profile.Profile) int64 {
+	if p == nil {
+		return 0
+	}
+	var n int64
+	for _, s := range p.Sample {
+		n += s.Value[0]
+	}
+	return n
+}
+
+func TestSplitProfile(t *testing.T) {
+	newSpanRecorder(t)
+	_, span := StartSpan(context.Background(), "encoded")
+	var err error
+	EndSpan(&err)
+	sc := span.SpanContext()
+
+	fn := &profile.Function{ID: 1, Name: "work"}
+	loc := &profile.Location{ID: 1, Line: []profile.Line{{Function: fn}}}
+	sample := func(labels map[string][]string) *profile.Sample {
+		return &profile.Sample{Location: []*profile.Location{loc}, Value: []int64{1}, Label: labels}
+	}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+		Function:   []*profile.Function{fn},
+		Location:   []*profile.Location{loc},
+		Sample: []*profile.Sample{
+			sample(map[string][]string{ProfTraceIDLabel: {"4bf92f3577b34da6a3ce929d0e0e4736"}, ProfSpanIDLabel: {"00f067aa0ba902b7"}}),
+			sample(map[string][]string{ProfTraceIDLabel: {"4bf92f3577b34da6a3ce929d0e0e4736"}, ProfSpanIDLabel: {"00f067aa0ba902b7"}}),
+			sample(map[string][]string{ProfTraceIDLabel: {"4bf92f3577b34da6a3ce929d0e0e4736"}, ProfSpanIDLabel: {"b7ad6b7169203331"}}),
+			// 未开启 profiling 标签时只有 trace 栈的编码标签
+			sample(map[string][]string{GRTTraceContextLen: {"1"}, getTargetKey(0): {EncodeTraceContext(sc)}}),
+			sample(nil),
+		},
+	}
+
+	bySpan := SplitProfile(p, GroupBySpan)
+	if len(bySpan) != 3 || sampleCount(bySpan["00f067aa0ba902b7"]) != 2 || sampleCount(bySpan[sc.SpanID().String()]) != 1 {
+		t.Errorf("unexpected split by span: %v", bySpan)
+	}
+	byTrace := SplitProfile(p, GroupByTrace)
+	if sampleCount(byTrace["4bf92f3577b34da6a3ce929d0e0e4736"]) != 3 {
+		t.Errorf("expect 3 samples for trace, got %v", byTrace)
+	}
+	if len(p.Sample) != 5 {
+		t.Errorf("expect original profile untouched")
+	}
+}
+
+func TestCaptureSpanProfiles(t *testing.T) {
+	if testing.Short() {
+		t.Skip("cpu profiling")
+	}
+	newSpanRecorder(t)
+	enableProfilingLabels(t, ProfilingLabelsIDs)
+
+	spanID := make(chan string, 1)
+	stop := make(chan struct{})
+	go func() {
+		IsolateProfLabel()
+		_, span := StartSpan(context.Background(), "busy")
+		var err error
+		defer EndSpan(&err)
+		spanID <- span.SpanContext().SpanID().String()
+		x := 0
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				for i := 0; i < 1e5; i++ {
+					x += i
+				}
+			}
+		}
+	}()
+	id := <-spanID
+	profiles, err := CaptureSpanProfiles(context.Background(), 300*time.Millisecond, GroupBySpan)
+	close(stop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := profiles[id]; !ok || len(p.Sample) == 0 {
+		t.Errorf("expect cpu samples for span %s, got %d profiles", id, len(profiles))
+	}
+}
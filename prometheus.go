@@ -0,0 +1,83 @@
+package probesdk
+
+import (
+	"context"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	otelprom "go.opentelemetry.io/otel/exporters/prometheus"
+	metric2 "go.opentelemetry.io/otel/sdk/metric"
+	"net"
+	"net/http"
+	"os"
+)
+
+// METRICS_EXPORTER_ENV 选择指标导出方式，逗号分隔：otlp（默认）、prometheus、none，可同时启用 otlp 和 prometheus
+const METRICS_EXPORTER_ENV = "OTEL_METRICS_EXPORTER"
+const PROMETHEUS_HOST_ENV = "OTEL_EXPORTER_PROMETHEUS_HOST"
+const PROMETHEUS_PORT_ENV = "OTEL_EXPORTER_PROMETHEUS_PORT"
+const PROMETHEUS_PATH_ENV = "PROBESDK_PROMETHEUS_PATH"
+
+const DEFAULT_PROMETHEUS_HOST = "0.0.0.0"
+const DEFAULT_PROMETHEUS_PORT = "9464"
+const DEFAULT_PROMETHEUS_PATH = "/metrics"
+
+// PrometheusReader 是 pull 模式的 metric reader，在本地地址上提供 Prometheus/OpenMetrics 文本格式的指标，
+// 资源属性以 target_info 指标暴露。关闭 MeterProvider 时会一并关闭 HTTP 服务
+type PrometheusReader struct {
+	metric2.Reader
+	server   *http.Server
+	listener net.Listener
+}
+
+// NewPrometheusReader 监听 addr 并在 path 上提供指标，可以和 OTLP 的 PeriodicReader 同时注册到一个 MeterProvider
+func NewPrometheusReader(addr, path string) (*PrometheusReader, error) {
+	registry := prometheus.NewRegistry()
+	exporter, err := otelprom.New(otelprom.WithRegisterer(registry))
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, promhttp.HandlerFor(registry, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+	server := &http.Server{Handler: mux}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			otel.Handle(err)
+		}
+	}()
+
+	return &PrometheusReader{Reader: exporter, server: server, listener: listener}, nil
+}
+
+// Addr 返回实际监听的地址，addr 使用 0 端口时可通过它获取端口
+func (r *PrometheusReader) Addr() string {
+	return r.listener.Addr().String()
+}
+
+func (r *PrometheusReader) Shutdown(ctx context.Context) error {
+	err := errors.Join(r.server.Shutdown(ctx), r.Reader.Shutdown(ctx))
+	// Serve 尚未开始时 server.Shutdown 不会关闭 listener
+	r.listener.Close()
+	return err
+}
+
+// 按环境变量创建 pull 模式的 reader
+func newPrometheusReaderFromEnv() (*PrometheusReader, error) {
+	host := getenvDefault(PROMETHEUS_HOST_ENV, DEFAULT_PROMETHEUS_HOST)
+	port := getenvDefault(PROMETHEUS_PORT_ENV, DEFAULT_PROMETHEUS_PORT)
+	path := getenvDefault(PROMETHEUS_PATH_ENV, DEFAULT_PROMETHEUS_PATH)
+	return NewPrometheusReader(net.JoinHostPort(host, port), path)
+}
+
+func getenvDefault(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,111 @@
+package probesdk
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	metric2 "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T, url, accept string) string {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expect 200, got %d", resp.StatusCode)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestPrometheusReader(t *testing.T) {
+	promReader, err := NewPrometheusReader("127.0.0.1:0", "/custom/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manual := metric2.NewManualReader()
+	res := resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName("prom-test"))
+	provider := metric2.NewMeterProvider(
+		metric2.WithResource(res),
+		metric2.WithReader(promReader),
+		metric2.WithReader(manual),
+	)
+	defer provider.Shutdown(context.Background())
+
+	r := NewMetricRegistry(provider.Meter("test"))
+	c, err := r.Counter(InstrumentSpec{Name: "orders.created"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Add(context.Background(), 3, attribute.String("region", "bj"))
+
+	url := "http://" + promReader.Addr() + "/custom/metrics"
+	text := scrape(t, url, "")
+	for _, want := range []string{`target_info{`, `service_name="prom-test"`, `orders_created_total{`, `region="bj"`} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expect %q in text format:\n%s", want, text)
+		}
+	}
+	om := scrape(t, url, "application/openmetrics-text; version=1.0.0")
+	if !strings.HasSuffix(strings.TrimSpace(om), "# EOF") {
+		t.Errorf("expect OpenMetrics format:\n%s", om)
+	}
+
+	// 调试接口不挂在抓取端口上
+	for _, path := range []string{CARDINALITY_DEBUG_PATH, HEALTH_DEBUG_PATH} {
+		resp, err := http.Get("http://" + promReader.Addr() + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("expect %s not served on scrape port, got %d", path, resp.StatusCode)
+		}
+	}
+
+	// 同时注册的 ManualReader 不受影响
+	if v := collectMetric(t, manual, "orders.created").Data; v == nil {
+		t.Errorf("expect push reader still collecting")
+	}
+
+	if err := provider.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := http.Get(url); err == nil {
+		t.Errorf("expect server stopped after shutdown")
+	}
+}
+
+func TestNewMetricReaders(t *testing.T) {
+	t.Setenv(METRICS_EXPORTER_ENV, "prometheus")
+	t.Setenv(PROMETHEUS_HOST_ENV, "127.0.0.1")
+	t.Setenv(PROMETHEUS_PORT_ENV, "0")
+	readers, err := newMetricReaders(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readers) != 1 {
+		t.Fatalf("expect only prometheus reader, got %d", len(readers))
+	}
+	pr, ok := readers[0].(*PrometheusReader)
+	if !ok {
+		t.Fatalf("expect *PrometheusReader, got %T", readers[0])
+	}
+	pr.Shutdown(context.Background())
+
+	t.Setenv(METRICS_EXPORTER_ENV, "otlp,bogus")
+	if _, err := newMetricReaders(context.Background()); err == nil {
+		t.Errorf("expect error for unknown exporter")
+	}
+}
@@ -0,0 +1,323 @@
+package probesdk
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"hash/fnv"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// PROPAGATORS_ENV 逗号分隔的传播器名称：tracecontext、baggage、b3、b3multi、jaeger、eagleeye、none
+const PROPAGATORS_ENV = "OTEL_PROPAGATORS"
+
+const DEFAULT_PROPAGATORS = "tracecontext,baggage"
+
+// NewPropagator 按名称组合传播器，extract 时后面的传播器覆盖前面的结果
+func NewPropagator(names ...string) (propagation.TextMapPropagator, error) {
+	var props []propagation.TextMapPropagator
+	for _, name := range names {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "tracecontext":
+			props = append(props, propagation.TraceContext{})
+		case "baggage":
+			props = append(props, propagation.Baggage{})
+		case "b3":
+			props = append(props, B3Propagator{SingleHeader: true})
+		case "b3multi":
+			props = append(props, B3Propagator{})
+		case "jaeger":
+			props = append(props, JaegerPropagator{})
+		case "eagleeye":
+			props = append(props, EagleEyePropagator{})
+		case "none", "":
+		default:
+			return nil, fmt.Errorf("unknown propagator %q", name)
+		}
+	}
+	return propagation.NewCompositeTextMapPropagator(props...), nil
+}
+
+// 读取 PROPAGATORS_ENV，未设置时使用 DEFAULT_PROPAGATORS
+func propagatorFromEnv() (propagation.TextMapPropagator, error) {
+	return NewPropagator(strings.Split(getenvDefault(PROPAGATORS_ENV, DEFAULT_PROPAGATORS), ",")...)
+}
+
+// 将不足长度的十六进制 ID 左侧补零，超长或含非十六进制字符时返回 false
+func padHexID(s string, size int) (string, bool) {
+	if s == "" || len(s) > size*2 {
+		return "", false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return "", false
+		}
+	}
+	return strings.Repeat("0", size*2-len(s)) + s, true
+}
+
+func parseTraceID(s string) (trace.TraceID, bool) {
+	padded, ok := padHexID(strings.ToLower(s), len(trace.TraceID{}))
+	if !ok {
+		return trace.TraceID{}, false
+	}
+	id, err := trace.TraceIDFromHex(padded)
+	return id, err == nil
+}
+
+func parseSpanID(s string) (trace.SpanID, bool) {
+	padded, ok := padHexID(strings.ToLower(s), len(trace.SpanID{}))
+	if !ok {
+		return trace.SpanID{}, false
+	}
+	id, err := trace.SpanIDFromHex(padded)
+	return id, err == nil
+}
+
+func remoteContext(ctx context.Context, cfg trace.SpanContextConfig) context.Context {
+	cfg.Remote = true
+	sc := trace.NewSpanContext(cfg)
+	if !sc.IsValid() {
+		return ctx
+	}
+	return trace.ContextWithRemoteSpanContext(ctx, sc)
+}
+
+// B3 头，见 https://github.com/openzipkin/b3-propagation
+const (
+	B3SingleHeader  = "b3"
+	B3TraceIDHeader = "x-b3-traceid"
+	B3SpanIDHeader  = "x-b3-spanid"
+	B3SampledHeader = "x-b3-sampled"
+	B3FlagsHeader   = "x-b3-flags"
+)
+
+// B3Propagator Zipkin 的 B3 传播格式。SingleHeader 决定 inject 使用单个 b3 头还是多个 X-B3-* 头，
+// extract 时两种格式都接受，优先使用单头
+type B3Propagator struct {
+	SingleHeader bool
+}
+
+func (b B3Propagator) Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	sampled := "0"
+	if sc.IsSampled() {
+		sampled = "1"
+	}
+	if b.SingleHeader {
+		carrier.Set(B3SingleHeader, sc.TraceID().String()+"-"+sc.SpanID().String()+"-"+sampled)
+		return
+	}
+	carrier.Set(B3TraceIDHeader, sc.TraceID().String())
+	carrier.Set(B3SpanIDHeader, sc.SpanID().String())
+	carrier.Set(B3SampledHeader, sampled)
+}
+
+func (b B3Propagator) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	if v := carrier.Get(B3SingleHeader); v != "" {
+		if cfg, ok := parseB3Single(v); ok {
+			return remoteContext(ctx, cfg)
+		}
+		return ctx
+	}
+	var cfg trace.SpanContextConfig
+	var ok bool
+	if cfg.TraceID, ok = parseTraceID(carrier.Get(B3TraceIDHeader)); !ok {
+		return ctx
+	}
+	if cfg.SpanID, ok = parseSpanID(carrier.Get(B3SpanIDHeader)); !ok {
+		return ctx
+	}
+	if carrier.Get(B3FlagsHeader) == "1" {
+		cfg.TraceFlags = trace.FlagsSampled
+	} else if sampled, ok := parseB3Sampled(carrier.Get(B3SampledHeader)); ok {
+		if sampled {
+			cfg.TraceFlags = trace.FlagsSampled
+		}
+	} else {
+		return ctx
+	}
+	return remoteContext(ctx, cfg)
+}
+
+// {TraceId}-{SpanId}-{SamplingState}-{ParentSpanId}，后两段可省略；只有采样位时不携带 span
+func parseB3Single(v string) (trace.SpanContextConfig, bool) {
+	var cfg trace.SpanContextConfig
+	parts := strings.Split(v, "-")
+	if len(parts) < 2 || len(parts) > 4 {
+		return cfg, false
+	}
+	var ok bool
+	if cfg.TraceID, ok = parseTraceID(parts[0]); !ok {
+		return cfg, false
+	}
+	if cfg.SpanID, ok = parseSpanID(parts[1]); !ok {
+		return cfg, false
+	}
+	if len(parts) >= 3 {
+		if parts[2] == "d" {
+			cfg.TraceFlags = trace.FlagsSampled
+		} else if sampled, ok := parseB3Sampled(parts[2]); !ok {
+			return cfg, false
+		} else if sampled {
+			cfg.TraceFlags = trace.FlagsSampled
+		}
+	}
+	if len(parts) == 4 {
+		if _, ok := parseSpanID(parts[3]); !ok {
+			return cfg, false
+		}
+	}
+	return cfg, true
+}
+
+// 采样位缺失时延后由本地决定，这里按未采样处理
+func parseB3Sampled(v string) (bool, bool) {
+	switch strings.ToLower(v) {
+	case "1", "true":
+		return true, true
+	case "0", "false", "":
+		return false, true
+	}
+	return false, false
+}
+
+func (b B3Propagator) Fields() []string {
+	if b.SingleHeader {
+		return []string{B3SingleHeader}
+	}
+	return []string{B3TraceIDHeader, B3SpanIDHeader, B3SampledHeader}
+}
+
+// JaegerHeader 格式为 {trace-id}:{span-id}:{parent-span-id}:{flags}
+const JaegerHeader = "uber-trace-id"
+
+// JaegerPropagator Jaeger 客户端的 uber-trace-id 传播格式
+type JaegerPropagator struct{}
+
+func (JaegerPropagator) Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	flags := "0"
+	if sc.IsSampled() {
+		flags = "1"
+	}
+	carrier.Set(JaegerHeader, sc.TraceID().String()+":"+sc.SpanID().String()+":0:"+flags)
+}
+
+func (JaegerPropagator) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	v := carrier.Get(JaegerHeader)
+	if v == "" {
+		return ctx
+	}
+	// 部分客户端会对 : 做 URL 编码
+	if unescaped, err := url.QueryUnescape(v); err == nil {
+		v = unescaped
+	}
+	parts := strings.Split(v, ":")
+	if len(parts) != 4 {
+		return ctx
+	}
+	var cfg trace.SpanContextConfig
+	var ok bool
+	if cfg.TraceID, ok = parseTraceID(parts[0]); !ok {
+		return ctx
+	}
+	if cfg.SpanID, ok = parseSpanID(parts[1]); !ok {
+		return ctx
+	}
+	// parent span id 通常为 0，只校验格式
+	if _, ok := padHexID(strings.ToLower(parts[2]), len(trace.SpanID{})); !ok {
+		return ctx
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return ctx
+	}
+	if flags&1 == 1 {
+		cfg.TraceFlags = trace.FlagsSampled
+	}
+	return remoteContext(ctx, cfg)
+}
+
+func (JaegerPropagator) Fields() []string {
+	return []string{JaegerHeader}
+}
+
+// 阿里云 EagleEye（鹰眼）/ ARMS 使用的头
+const (
+	EagleEyeTraceIDHeader = "eagleeye-traceid"
+	EagleEyeSpanIDHeader  = "eagleeye-spanid"
+	EagleEyeRpcIDHeader   = "eagleeye-rpcid"
+	EagleEyeSampledHeader = "eagleeye-sampled"
+)
+
+// EagleEyeTraceStateKey 保存上游的 RpcId，向下游传播时原样带上
+const EagleEyeTraceStateKey = "eagleeye"
+
+// EagleEyePropagator 兼容 EagleEye 风格的头。EagleEye 的 TraceId 一般为 30 位十六进制，左侧补零为 32 位；
+// 上游没有 SpanId 时由 RpcId 哈希得到，RpcId 保存在 tracestate 中
+type EagleEyePropagator struct{}
+
+func (EagleEyePropagator) Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	sampled := "s0"
+	if sc.IsSampled() {
+		sampled = "s1"
+	}
+	rpcID := sc.TraceState().Get(EagleEyeTraceStateKey)
+	if rpcID == "" {
+		rpcID = "0"
+	}
+	carrier.Set(EagleEyeTraceIDHeader, sc.TraceID().String())
+	carrier.Set(EagleEyeSpanIDHeader, sc.SpanID().String())
+	carrier.Set(EagleEyeRpcIDHeader, rpcID)
+	carrier.Set(EagleEyeSampledHeader, sampled)
+}
+
+func (EagleEyePropagator) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	var cfg trace.SpanContextConfig
+	var ok bool
+	if cfg.TraceID, ok = parseTraceID(carrier.Get(EagleEyeTraceIDHeader)); !ok {
+		return ctx
+	}
+	rpcID := carrier.Get(EagleEyeRpcIDHeader)
+	if v := carrier.Get(EagleEyeSpanIDHeader); v != "" {
+		if cfg.SpanID, ok = parseSpanID(v); !ok {
+			return ctx
+		}
+	} else if rpcID != "" {
+		h := fnv.New64a()
+		h.Write([]byte(rpcID))
+		copy(cfg.SpanID[:], h.Sum(nil))
+	} else {
+		return ctx
+	}
+	if rpcID != "" {
+		if ts, err := cfg.TraceState.Insert(EagleEyeTraceStateKey, rpcID); err == nil {
+			cfg.TraceState = ts
+		}
+	}
+	// 鹰眼默认采样
+	switch strings.ToLower(carrier.Get(EagleEyeSampledHeader)) {
+	case "s0", "0", "false":
+	default:
+		cfg.TraceFlags = trace.FlagsSampled
+	}
+	return remoteContext(ctx, cfg)
+}
+
+func (EagleEyePropagator) Fields() []string {
+	return []string{EagleEyeTraceIDHeader, EagleEyeSpanIDHeader, EagleEyeRpcIDHeader, EagleEyeSampledHeader}
+}
@@ -0,0 +1,152 @@
+package probesdk
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"testing"
+)
+
+var testSpanContext = trace.NewSpanContext(trace.SpanContextConfig{
+	TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+	SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+	TraceFlags: trace.FlagsSampled,
+})
+
+func extractFrom(p propagation.TextMapPropagator, headers map[string]string) trace.SpanContext {
+	h := http.Header{}
+	for k, v := range headers {
+		h.Set(k, v)
+	}
+	return trace.SpanContextFromContext(p.Extract(context.Background(), propagation.HeaderCarrier(h)))
+}
+
+func TestPropagatorRoundTrip(t *testing.T) {
+	unsampled := testSpanContext.WithTraceFlags(0)
+	props := map[string]propagation.TextMapPropagator{
+		"b3":       B3Propagator{SingleHeader: true},
+		"b3multi":  B3Propagator{},
+		"jaeger":   JaegerPropagator{},
+		"eagleeye": EagleEyePropagator{},
+	}
+	for name, p := range props {
+		for _, sc := range []trace.SpanContext{testSpanContext, unsampled} {
+			h := http.Header{}
+			p.Inject(trace.ContextWithSpanContext(context.Background(), sc), propagation.HeaderCarrier(h))
+			for _, f := range p.Fields() {
+				if h.Get(f) == "" {
+					t.Errorf("%s: expect field %s injected, got %v", name, f, h)
+				}
+			}
+			got := trace.SpanContextFromContext(p.Extract(context.Background(), propagation.HeaderCarrier(h)))
+			if got.TraceID() != sc.TraceID() || got.SpanID() != sc.SpanID() || got.IsSampled() != sc.IsSampled() || !got.IsRemote() {
+				t.Errorf("%s: round trip mismatch, injected %v, extracted %v", name, sc, got)
+			}
+		}
+
+		h := http.Header{}
+		p.Inject(context.Background(), propagation.HeaderCarrier(h))
+		if len(h) != 0 {
+			t.Errorf("%s: expect nothing injected without span, got %v", name, h)
+		}
+	}
+}
+
+func TestB3Extract(t *testing.T) {
+	p := B3Propagator{}
+	sc := extractFrom(p, map[string]string{"b3": "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-d-05e3ac9a4f6e3b90"})
+	if !sc.IsValid() || !sc.IsSampled() || sc.TraceID().String() != "80f198ee56343ba864fe8b2a57d3eff7" {
+		t.Errorf("expect debug flag treated as sampled, got %v", sc)
+	}
+	// 64 位的 trace ID 左侧补零
+	sc = extractFrom(p, map[string]string{"X-B3-TraceId": "64fe8b2a57d3eff7", "X-B3-SpanId": "e457b5a2e4d86bd1", "X-B3-Sampled": "1"})
+	if sc.TraceID().String() != "000000000000000064fe8b2a57d3eff7" || !sc.IsSampled() {
+		t.Errorf("expect padded 64-bit trace id, got %v", sc)
+	}
+
+	malformed := []map[string]string{
+		{"b3": "0"},
+		{"b3": "80f198ee56343ba864fe8b2a57d3eff7"},
+		{"b3": "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-x"},
+		{"b3": "zzf198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1"},
+		{"b3": "80f198ee56343ba864fe8b2a57d3eff7ab-e457b5a2e4d86bd1-1"},
+		{"X-B3-TraceId": "80f198ee56343ba864fe8b2a57d3eff7"},
+		{"X-B3-TraceId": "80f198ee56343ba864fe8b2a57d3eff7", "X-B3-SpanId": "e457b5a2e4d86bd1", "X-B3-Sampled": "yes"},
+		{"X-B3-TraceId": "00000000000000000000000000000000", "X-B3-SpanId": "e457b5a2e4d86bd1"},
+	}
+	for _, headers := range malformed {
+		if sc := extractFrom(p, headers); sc.IsValid() {
+			t.Errorf("expect %v rejected, got %v", headers, sc)
+		}
+	}
+}
+
+func TestJaegerExtract(t *testing.T) {
+	p := JaegerPropagator{}
+	sc := extractFrom(p, map[string]string{"uber-trace-id": "64fe8b2a57d3eff7%3Ae457b5a2e4d86bd1%3A0%3A3"})
+	if sc.TraceID().String() != "000000000000000064fe8b2a57d3eff7" || !sc.IsSampled() {
+		t.Errorf("expect url-encoded header accepted, got %v", sc)
+	}
+	for _, v := range []string{"", "a:b:c", "64fe8b2a57d3eff7:e457b5a2e4d86bd1:0", "64fe8b2a57d3eff7:e457b5a2e4d86bd1:0:zz", "64fe8b2a57d3eff7:0:0:1"} {
+		if sc := extractFrom(p, map[string]string{"uber-trace-id": v}); sc.IsValid() {
+			t.Errorf("expect %q rejected, got %v", v, sc)
+		}
+	}
+}
+
+func TestEagleEyeExtract(t *testing.T) {
+	p := EagleEyePropagator{}
+	sc := extractFrom(p, map[string]string{"EagleEye-TraceId": "0ad1348f1403169275002100356696", "EagleEye-RpcId": "0.1.2"})
+	if sc.TraceID().String() != "000ad1348f1403169275002100356696" || !sc.SpanID().IsValid() || !sc.IsSampled() {
+		t.Errorf("expect 30-digit trace id padded and span derived from rpc id, got %v", sc)
+	}
+	if sc.TraceState().Get(EagleEyeTraceStateKey) != "0.1.2" {
+		t.Errorf("expect rpc id kept in tracestate, got %v", sc.TraceState())
+	}
+
+	h := http.Header{}
+	p.Inject(trace.ContextWithSpanContext(context.Background(), sc), propagation.HeaderCarrier(h))
+	if h.Get(EagleEyeRpcIDHeader) != "0.1.2" {
+		t.Errorf("expect rpc id propagated, got %v", h)
+	}
+
+	if sc := extractFrom(p, map[string]string{"EagleEye-TraceId": "0ad1348f1403169275002100356696", "EagleEye-Sampled": "s0", "EagleEye-SpanId": "e457b5a2e4d86bd1"}); sc.IsSampled() {
+		t.Errorf("expect s0 unsampled, got %v", sc)
+	}
+	for _, headers := range []map[string]string{
+		{"EagleEye-TraceId": "0ad1348f1403169275002100356696"},
+		{"EagleEye-TraceId": "not-a-trace", "EagleEye-RpcId": "0"},
+		{"EagleEye-TraceId": "0ad1348f1403169275002100356696", "EagleEye-SpanId": "xyz"},
+	} {
+		if sc := extractFrom(p, headers); sc.IsValid() {
+			t.Errorf("expect %v rejected, got %v", headers, sc)
+		}
+	}
+}
+
+func TestNewPropagator(t *testing.T) {
+	p, err := NewPropagator("tracecontext", "b3multi", "eagleeye")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := http.Header{}
+	p.Inject(trace.ContextWithSpanContext(context.Background(), testSpanContext), propagation.HeaderCarrier(h))
+	for _, f := range []string{"traceparent", B3TraceIDHeader, EagleEyeTraceIDHeader} {
+		if h.Get(f) == "" {
+			t.Errorf("expect %s injected, got %v", f, h)
+		}
+	}
+	if _, err := NewPropagator("xray"); err == nil {
+		t.Errorf("expect error for unknown propagator")
+	}
+
+	t.Setenv(PROPAGATORS_ENV, "jaeger")
+	p, err = propagatorFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields := p.Fields(); len(fields) != 1 || fields[0] != JaegerHeader {
+		t.Errorf("expect only jaeger fields, got %v", fields)
+	}
+}
@@ -0,0 +1,197 @@
+package probesdk
+
+import (
+	"bufio"
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"io/fs"
+	"os"
+	"regexp"
+	"runtime/debug"
+	"strings"
+)
+
+// 显式指定 service.name / service.namespace，优先级高于 OTEL_SERVICE_NAME 和程序名
+const SERVICE_NAME_ENV = "PROBESDK_SERVICE_NAME"
+const SERVICE_NAMESPACE_ENV = "PROBESDK_SERVICE_NAMESPACE"
+
+// Kubernetes downward API 注入的环境变量，每项按顺序取第一个非空值
+var (
+	K8S_POD_NAME_ENVS  = []string{"K8S_POD_NAME", "POD_NAME"}
+	K8S_POD_UID_ENVS   = []string{"K8S_POD_UID", "POD_UID"}
+	K8S_NAMESPACE_ENVS = []string{"K8S_NAMESPACE", "POD_NAMESPACE"}
+	K8S_NODE_NAME_ENVS = []string{"K8S_NODE_NAME", "NODE_NAME"}
+)
+
+const serviceAccountNamespaceFile = "var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// 文件系统路径均相对于根目录，测试中可替换为 fstest.MapFS
+var rootFS fs.FS = os.DirFS("/")
+
+// ContainerDetector 从 /proc/self/cgroup 和 /proc/self/mountinfo 中解析 container.id
+type ContainerDetector struct {
+	FS fs.FS
+}
+
+var containerIDRegexp = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// cgroup v2 下 cgroup 文件中没有容器 ID，从容器运行时挂载的 hostname 等文件路径中获取。
+// containerd 的 sandboxes/<id> 是 pause 容器的 ID，同一 pod 的容器共用，不能当作 container.id
+var mountinfoContainerIDRegexp = regexp.MustCompile(`/containers/([0-9a-f]{64})/`)
+
+func (d ContainerDetector) Detect(ctx context.Context) (*resource.Resource, error) {
+	fsys := d.FS
+	if fsys == nil {
+		fsys = rootFS
+	}
+	id := scanFile(fsys, "proc/self/cgroup", func(line string) string {
+		// 取最后一段路径中的 ID，如 /kubepods/.../docker-<id>.scope
+		ids := containerIDRegexp.FindAllString(line[strings.LastIndex(line, "/")+1:], -1)
+		if len(ids) == 0 {
+			return ""
+		}
+		return ids[len(ids)-1]
+	})
+	if id == "" {
+		id = scanFile(fsys, "proc/self/mountinfo", func(line string) string {
+			if m := mountinfoContainerIDRegexp.FindStringSubmatch(line); m != nil {
+				return m[1]
+			}
+			return ""
+		})
+	}
+	if id == "" {
+		return resource.Empty(), nil
+	}
+	return resource.NewSchemaless(semconv.ContainerID(id)), nil
+}
+
+// 逐行调用 match，返回第一个非空结果，文件不存在时返回空
+func scanFile(fsys fs.FS, name string, match func(line string) string) string {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if v := match(scanner.Text()); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// KubernetesDetector 从 downward API 环境变量和 service account 的 namespace 文件中获取 k8s.* 属性
+type KubernetesDetector struct {
+	FS     fs.FS
+	Getenv func(string) string
+}
+
+func (d KubernetesDetector) Detect(ctx context.Context) (*resource.Resource, error) {
+	fsys, getenv := d.FS, d.Getenv
+	if fsys == nil {
+		fsys = rootFS
+	}
+	if getenv == nil {
+		getenv = os.Getenv
+	}
+	lookup := func(keys []string) string {
+		for _, k := range keys {
+			if v := getenv(k); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+
+	var attrs []attribute.KeyValue
+	if v := lookup(K8S_POD_NAME_ENVS); v != "" {
+		attrs = append(attrs, semconv.K8SPodName(v))
+	}
+	if v := lookup(K8S_POD_UID_ENVS); v != "" {
+		attrs = append(attrs, semconv.K8SPodUID(v))
+	}
+	namespace := lookup(K8S_NAMESPACE_ENVS)
+	if namespace == "" {
+		if data, err := fs.ReadFile(fsys, serviceAccountNamespaceFile); err == nil {
+			namespace = strings.TrimSpace(string(data))
+		}
+	}
+	if namespace != "" {
+		attrs = append(attrs, semconv.K8SNamespaceName(namespace))
+	}
+	if v := lookup(K8S_NODE_NAME_ENVS); v != "" {
+		attrs = append(attrs, semconv.K8SNodeName(v))
+	}
+	if len(attrs) == 0 {
+		return resource.Empty(), nil
+	}
+	return resource.NewSchemaless(attrs...), nil
+}
+
+// VCSRevisionKey 构建时的 VCS 提交，取自 runtime/debug 的 vcs.revision
+const VCSRevisionKey = attribute.Key("vcs.ref.head.revision")
+const VCSModifiedKey = attribute.Key("vcs.modified")
+
+// BuildInfoDetector 从 runtime/debug.ReadBuildInfo 中获取 service.version 和 VCS 信息
+type BuildInfoDetector struct {
+	ReadBuildInfo func() (*debug.BuildInfo, bool)
+}
+
+func (d BuildInfoDetector) Detect(ctx context.Context) (*resource.Resource, error) {
+	read := d.ReadBuildInfo
+	if read == nil {
+		read = debug.ReadBuildInfo
+	}
+	info, ok := read()
+	if !ok {
+		return resource.Empty(), nil
+	}
+
+	var attrs []attribute.KeyValue
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		attrs = append(attrs, semconv.ServiceVersion(v))
+	}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			attrs = append(attrs, VCSRevisionKey.String(s.Value))
+		case "vcs.modified":
+			attrs = append(attrs, VCSModifiedKey.Bool(s.Value == "true"))
+		}
+	}
+	if len(attrs) == 0 {
+		return resource.Empty(), nil
+	}
+	return resource.NewSchemaless(attrs...), nil
+}
+
+// ServiceDetector 设置显式指定的 service.name / service.namespace，未指定时读取 SERVICE_NAME_ENV 和 SERVICE_NAMESPACE_ENV
+type ServiceDetector struct {
+	Name      string
+	Namespace string
+}
+
+func (d ServiceDetector) Detect(ctx context.Context) (*resource.Resource, error) {
+	name, namespace := d.Name, d.Namespace
+	if name == "" {
+		name = os.Getenv(SERVICE_NAME_ENV)
+	}
+	if namespace == "" {
+		namespace = os.Getenv(SERVICE_NAMESPACE_ENV)
+	}
+	var attrs []attribute.KeyValue
+	if name != "" {
+		attrs = append(attrs, semconv.ServiceName(name))
+	}
+	if namespace != "" {
+		attrs = append(attrs, semconv.ServiceNamespace(namespace))
+	}
+	if len(attrs) == 0 {
+		return resource.Empty(), nil
+	}
+	return resource.NewSchemaless(attrs...), nil
+}
@@ -0,0 +1,152 @@
+package probesdk
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"runtime/debug"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+const testContainerID = "3f4e2c1b0a9d8e7f6a5b4c3d2e1f0a9b8c7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f"
+
+func detect(t *testing.T, d resource.Detector) *resource.Resource {
+	r, err := d.Detect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func resourceValue(r *resource.Resource, key attribute.Key) string {
+	v, _ := r.Set().Value(key)
+	return v.Emit()
+}
+
+const testSandboxID = "9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f1a0b9c8d7e6f5a4b3c2d1e0f9a8b"
+
+// containerd 下 /etc/hostname 等文件从 pod 的 sandbox 目录挂载
+const containerdMountinfo = `1463 1368 0:305 / / rw,relatime master:380 - overlay overlay rw,lowerdir=/var/lib/containerd/io.containerd.snapshotter.v1.overlayfs/snapshots/812/fs,upperdir=/var/lib/containerd/io.containerd.snapshotter.v1.overlayfs/snapshots/905/fs
+1464 1463 0:308 / /proc rw,nosuid,nodev,noexec,relatime - proc proc rw
+1475 1463 254:1 /var/lib/kubelet/pods/5c1f2d9e-3b7a-4e6f-9d2c-1a0b8e7f6d5c/etc-hosts /etc/hosts rw,relatime - ext4 /dev/vda1 rw
+1476 1463 254:1 /var/lib/kubelet/pods/5c1f2d9e-3b7a-4e6f-9d2c-1a0b8e7f6d5c/containers/app/4f1d2a3c /dev/termination-log rw,relatime - ext4 /dev/vda1 rw
+1477 1463 254:1 /var/lib/containerd/io.containerd.grpc.v1.cri/sandboxes/` + testSandboxID + `/hostname /etc/hostname rw,relatime - ext4 /dev/vda1 rw
+1478 1463 254:1 /var/lib/containerd/io.containerd.grpc.v1.cri/sandboxes/` + testSandboxID + `/resolv.conf /etc/resolv.conf rw,relatime - ext4 /dev/vda1 rw
+`
+
+func TestContainerDetector(t *testing.T) {
+	cases := map[string]fstest.MapFS{
+		"cgroup v1": {
+			"proc/self/cgroup": {Data: []byte("12:pids:/kubepods/burstable/pod1234/" + testContainerID + "\n")},
+		},
+		"systemd scope": {
+			"proc/self/cgroup": {Data: []byte("0::/system.slice/docker-" + testContainerID + ".scope\n")},
+		},
+		"cgroup v2 mountinfo": {
+			"proc/self/cgroup":    {Data: []byte("0::/\n")},
+			"proc/self/mountinfo": {Data: []byte("612 590 254:1 /docker/containers/" + testContainerID + "/hostname /etc/hostname rw,relatime - ext4 /dev/vda1 rw\n")},
+		},
+	}
+	for name, fsys := range cases {
+		if id := resourceValue(detect(t, ContainerDetector{FS: fsys}), semconv.ContainerIDKey); id != testContainerID {
+			t.Errorf("%s: expect %s, got %q", name, testContainerID, id)
+		}
+	}
+
+	// 只有 sandbox 路径时不上报 pause 容器的 ID
+	containerd := fstest.MapFS{
+		"proc/self/cgroup":    {Data: []byte("0::/\n")},
+		"proc/self/mountinfo": {Data: []byte(containerdMountinfo)},
+	}
+	if r := detect(t, ContainerDetector{FS: containerd}); r.Len() != 0 {
+		t.Errorf("expect no container.id from sandbox mounts, got %v", r)
+	}
+	// cgroup 中的 ID 优先于 mountinfo
+	containerd["proc/self/cgroup"] = &fstest.MapFile{Data: []byte("0::/kubepods.slice/cri-containerd-" + testContainerID + ".scope\n")}
+	if id := resourceValue(detect(t, ContainerDetector{FS: containerd}), semconv.ContainerIDKey); id != testContainerID {
+		t.Errorf("expect cgroup container id, got %q", id)
+	}
+
+	if r := detect(t, ContainerDetector{FS: fstest.MapFS{}}); r.Len() != 0 {
+		t.Errorf("expect empty resource outside a container, got %v", r)
+	}
+}
+
+func TestKubernetesDetector(t *testing.T) {
+	env := map[string]string{"POD_NAME": "api-7d9f", "K8S_NODE_NAME": "node-1"}
+	d := KubernetesDetector{
+		FS: fstest.MapFS{
+			serviceAccountNamespaceFile: {Data: []byte("payments\n")},
+		},
+		Getenv: func(k string) string { return env[k] },
+	}
+	r := detect(t, d)
+	want := map[attribute.Key]string{
+		semconv.K8SPodNameKey:       "api-7d9f",
+		semconv.K8SNodeNameKey:      "node-1",
+		semconv.K8SNamespaceNameKey: "payments",
+	}
+	for k, v := range want {
+		if got := resourceValue(r, k); got != v {
+			t.Errorf("%s: expect %q, got %q", k, v, got)
+		}
+	}
+
+	empty := KubernetesDetector{FS: fstest.MapFS{}, Getenv: func(string) string { return "" }}
+	if r := detect(t, empty); r.Len() != 0 {
+		t.Errorf("expect empty resource outside kubernetes, got %v", r)
+	}
+}
+
+func TestBuildInfoDetector(t *testing.T) {
+	d := BuildInfoDetector{ReadBuildInfo: func() (*debug.BuildInfo, bool) {
+		return &debug.BuildInfo{
+			Main: debug.Module{Path: "example.com/server", Version: "v1.4.2"},
+			Settings: []debug.BuildSetting{
+				{Key: "vcs.revision", Value: "abc123"},
+				{Key: "vcs.modified", Value: "true"},
+			},
+		}, true
+	}}
+	r := detect(t, d)
+	if v := resourceValue(r, semconv.ServiceVersionKey); v != "v1.4.2" {
+		t.Errorf("expect service.version v1.4.2, got %q", v)
+	}
+	if v := resourceValue(r, VCSRevisionKey); v != "abc123" {
+		t.Errorf("expect revision abc123, got %q", v)
+	}
+	if v := resourceValue(r, VCSModifiedKey); v != "true" {
+		t.Errorf("expect modified true, got %q", v)
+	}
+
+	devel := BuildInfoDetector{ReadBuildInfo: func() (*debug.BuildInfo, bool) {
+		return &debug.BuildInfo{Main: debug.Module{Version: "(devel)"}}, true
+	}}
+	if r := detect(t, devel); r.Len() != 0 {
+		t.Errorf("expect no version for devel build, got %v", r)
+	}
+}
+
+func TestNewResourceServiceOverride(t *testing.T) {
+	t.Setenv("OTEL_SERVICE_NAME", "from-otel")
+	r, _ := newResource(context.Background())
+	if v := resourceValue(r, semconv.ServiceNameKey); v != "from-otel" {
+		t.Errorf("expect OTEL_SERVICE_NAME to override program name, got %q", v)
+	}
+
+	t.Setenv(SERVICE_NAME_ENV, "checkout")
+	t.Setenv(SERVICE_NAMESPACE_ENV, "shop")
+	r, _ = newResource(context.Background())
+	if v := resourceValue(r, semconv.ServiceNameKey); v != "checkout" {
+		t.Errorf("expect explicit service.name, got %q", v)
+	}
+	if v := resourceValue(r, semconv.ServiceNamespaceKey); v != "shop" {
+		t.Errorf("expect explicit service.namespace, got %q", v)
+	}
+	if !strings.Contains(r.String(), "process.pid") {
+		t.Errorf("expect process attributes kept, got %v", r)
+	}
+}
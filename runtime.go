@@ -0,0 +1,183 @@
+package probesdk
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	"math"
+	"os"
+	"runtime/metrics"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// RUNTIME_METRICS_ENV 为 true 时 initMeter 会注册 Go 运行时指标
+const RUNTIME_METRICS_ENV = "PROBESDK_RUNTIME_METRICS"
+
+// 运行时直方图（GC 停顿、调度延迟）按这些分位数上报为 gauge。
+// runtime/metrics 的直方图从进程启动开始累计，分位数按相邻两次采集之间新增的计数计算，区间内没有新样本时不上报
+var runtimeQuantiles = []float64{0.5, 0.9, 0.99, 1}
+
+type runtimeGauge struct {
+	spec   InstrumentSpec
+	sample string
+	kind   InstrumentKind
+}
+
+var runtimeGauges = []runtimeGauge{
+	{InstrumentSpec{Name: "process.runtime.go.goroutines", Description: "Number of live goroutines.", Unit: "{goroutine}"}, "/sched/goroutines:goroutines", ObservableUpDownCounterKind},
+	{InstrumentSpec{Name: "process.runtime.go.gomaxprocs", Description: "Current GOMAXPROCS setting.", Unit: "{thread}"}, "/sched/gomaxprocs:threads", ObservableUpDownCounterKind},
+	{InstrumentSpec{Name: "process.runtime.go.gc.count", Description: "Number of completed GC cycles.", Unit: "{gc_cycle}"}, "/gc/cycles/total:gc-cycles", ObservableCounterKind},
+	{InstrumentSpec{Name: "process.runtime.go.mem.heap_alloc", Description: "Bytes of live and not-yet-swept heap objects.", Unit: "By"}, "/memory/classes/heap/objects:bytes", ObservableUpDownCounterKind},
+	{InstrumentSpec{Name: "process.runtime.go.mem.heap_goal", Description: "Heap size target for the end of the GC cycle.", Unit: "By"}, "/gc/heap/goal:bytes", ObservableUpDownCounterKind},
+	{InstrumentSpec{Name: "process.runtime.go.mem.total", Description: "All memory mapped by the Go runtime.", Unit: "By"}, "/memory/classes/total:bytes", ObservableUpDownCounterKind},
+}
+
+var runtimeHistograms = []runtimeGauge{
+	{InstrumentSpec{Name: "process.runtime.go.gc.pause", Description: "Quantiles of stop-the-world GC pause latency since the previous collection.", Unit: "s"}, "/sched/pauses/total/gc:seconds", ObservableGaugeKind},
+	{InstrumentSpec{Name: "process.runtime.go.sched.latency", Description: "Quantiles of time goroutines spend runnable before running since the previous collection.", Unit: "s"}, "/sched/latencies:seconds", ObservableGaugeKind},
+}
+
+// RegisterRuntimeMetrics 在 r 上注册基于 runtime/metrics 的运行时指标，数据在每次采集时读取。
+// 当前 Go 版本不支持的指标会被跳过。同时开始按深度统计 trace 栈的压栈次数
+func RegisterRuntimeMetrics(r *MetricRegistry) error {
+	stackDepthStatsEnabled.Store(true)
+	if err := r.ObservableCounter(InstrumentSpec{
+		Name:          "probesdk.trace_stack.pushes",
+		Description:   "Pushes onto goroutine trace stacks by resulting depth.",
+		Unit:          "{entry}",
+		AttributeKeys: []attribute.Key{"depth"},
+	}, observeStackDepths); err != nil {
+		return err
+	}
+
+	for _, g := range runtimeGauges {
+		name := g.sample
+		fn := func(ctx context.Context, observe func(float64, ...attribute.KeyValue)) error {
+			if v, ok := readRuntimeValue(name); ok {
+				observe(v)
+			}
+			return nil
+		}
+		var err error
+		if g.kind == ObservableCounterKind {
+			err = r.ObservableCounter(g.spec, fn)
+		} else {
+			err = r.ObservableUpDownCounter(g.spec, fn)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, h := range runtimeHistograms {
+		name := h.sample
+		h.spec.AttributeKeys = []attribute.Key{"quantile"}
+		delta := &histogramDelta{}
+		err := r.ObservableGauge(h.spec, func(ctx context.Context, observe func(float64, ...attribute.KeyValue)) error {
+			s := []metrics.Sample{{Name: name}}
+			metrics.Read(s)
+			if s[0].Value.Kind() != metrics.KindFloat64Histogram {
+				return nil
+			}
+			hist := delta.next(s[0].Value.Float64Histogram())
+			for _, q := range runtimeQuantiles {
+				if v, ok := histogramQuantile(hist, q); ok {
+					observe(v, attribute.String("quantile", strconv.FormatFloat(q, 'f', -1, 64)))
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runtimeMetricsEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv(RUNTIME_METRICS_ENV))
+	return enabled
+}
+
+func readRuntimeValue(name string) (float64, bool) {
+	s := []metrics.Sample{{Name: name}}
+	metrics.Read(s)
+	switch s[0].Value.Kind() {
+	case metrics.KindUint64:
+		return float64(s[0].Value.Uint64()), true
+	case metrics.KindFloat64:
+		return s[0].Value.Float64(), true
+	}
+	return 0, false
+}
+
+// histogramDelta 记住上一次采集时累计直方图的计数，next 返回两次采集之间新增的部分。
+// 多个 reader 共用时每个 reader 看到的区间会被其他 reader 的采集切开
+type histogramDelta struct {
+	mu   sync.Mutex
+	prev []uint64
+}
+
+func (d *histogramDelta) next(h *metrics.Float64Histogram) *metrics.Float64Histogram {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delta := &metrics.Float64Histogram{Counts: slices.Clone(h.Counts), Buckets: h.Buckets}
+	// 桶的边界在进程内不变，长度不同说明不是同一个直方图，按第一次采集处理
+	if len(d.prev) == len(h.Counts) {
+		for i := range delta.Counts {
+			delta.Counts[i] -= d.prev[i]
+		}
+	}
+	d.prev = slices.Clone(h.Counts)
+	return delta
+}
+
+// 返回分位数 q 所在桶的上界，上界为 +Inf 时取下界
+func histogramQuantile(h *metrics.Float64Histogram, q float64) (float64, bool) {
+	var total uint64
+	for _, c := range h.Counts {
+		total += c
+	}
+	if total == 0 {
+		return 0, false
+	}
+	target := uint64(math.Ceil(q * float64(total)))
+	if target == 0 {
+		target = 1
+	}
+	var seen uint64
+	for i, c := range h.Counts {
+		seen += c
+		if seen >= target {
+			if upper := h.Buckets[i+1]; !math.IsInf(upper, 1) {
+				return upper, true
+			}
+			return h.Buckets[i], true
+		}
+	}
+	return 0, false
+}
+
+func observeStackMismatches(ctx context.Context, observe func(float64, ...attribute.KeyValue)) error {
+	for reason, n := range stackMismatches {
+		observe(float64(n.Load()), attribute.String("reason", reason))
+	}
+	return nil
+}
+
+// depth 属性为压栈后的深度，最后一个桶为 "16+"
+func observeStackDepths(ctx context.Context, observe func(float64, ...attribute.KeyValue)) error {
+	for depth := 1; depth <= maxTrackedStackDepth; depth++ {
+		n := stackDepthCounts[depth].Load()
+		if n == 0 {
+			continue
+		}
+		label := strconv.Itoa(depth)
+		if depth == maxTrackedStackDepth {
+			label += "+"
+		}
+		observe(float64(n), attribute.String("depth", label))
+	}
+	return nil
+}
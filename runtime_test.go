@@ -0,0 +1,121 @@
+package probesdk
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	metric2 "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"runtime"
+	"runtime/metrics"
+	"testing"
+)
+
+func TestRuntimeMetrics(t *testing.T) {
+	r, reader := newTestRegistry()
+	if err := RegisterRuntimeMetrics(r); err != nil {
+		t.Fatal(err)
+	}
+	runtime.GC()
+
+	goroutines := collectMetric(t, reader, "process.runtime.go.goroutines").Data.(metricdata.Sum[float64]).DataPoints[0].Value
+	if goroutines < 1 {
+		t.Errorf("expect at least 1 goroutine, got %v", goroutines)
+	}
+	if v := collectMetric(t, reader, "process.runtime.go.gc.count").Data.(metricdata.Sum[float64]).DataPoints[0].Value; v < 1 {
+		t.Errorf("expect at least 1 gc cycle, got %v", v)
+	}
+	if v := collectMetric(t, reader, "process.runtime.go.mem.heap_alloc").Data.(metricdata.Sum[float64]).DataPoints[0].Value; v <= 0 {
+		t.Errorf("expect heap bytes, got %v", v)
+	}
+	// 分位数只覆盖上一次采集之后的 GC
+	runtime.GC()
+	pause := collectMetric(t, reader, "process.runtime.go.gc.pause").Data.(metricdata.Gauge[float64]).DataPoints
+	if len(pause) != len(runtimeQuantiles) {
+		t.Errorf("expect %d quantiles, got %d", len(runtimeQuantiles), len(pause))
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	h := &metrics.Float64Histogram{
+		Counts:  []uint64{5, 4, 1},
+		Buckets: []float64{0, 1, 2, 4},
+	}
+	for q, want := range map[float64]float64{0.5: 1, 0.9: 2, 1: 4} {
+		if v, _ := histogramQuantile(h, q); v != want {
+			t.Errorf("quantile %v: expect %v, got %v", q, want, v)
+		}
+	}
+	if _, ok := histogramQuantile(&metrics.Float64Histogram{Counts: []uint64{0}, Buckets: []float64{0, 1}}, 0.5); ok {
+		t.Errorf("expect no quantile for empty histogram")
+	}
+}
+
+func TestHistogramDelta(t *testing.T) {
+	d := &histogramDelta{}
+	buckets := []float64{0, 1, 2, 4}
+	first := d.next(&metrics.Float64Histogram{Counts: []uint64{100, 0, 0}, Buckets: buckets})
+	if v, _ := histogramQuantile(first, 0.99); v != 1 {
+		t.Errorf("expect first collection to cover everything so far, got p99 %v", v)
+	}
+	// 之前的样本都在第一个桶，新增的只在最后一个桶，按累计计数算的 p50 看不出变化
+	second := d.next(&metrics.Float64Histogram{Counts: []uint64{100, 0, 3}, Buckets: buckets})
+	if v, _ := histogramQuantile(second, 0.5); v != 4 {
+		t.Errorf("expect p50 of the interval only, got %v", v)
+	}
+	if _, ok := histogramQuantile(d.next(&metrics.Float64Histogram{Counts: []uint64{100, 0, 3}, Buckets: buckets}), 0.5); ok {
+		t.Errorf("expect no quantile for an interval without samples")
+	}
+}
+
+// 按属性值汇总异步计数器的当前值，还没有数据点时返回空 map
+func sumByAttribute(t *testing.T, reader *metric2.ManualReader, name string, key attribute.Key) map[string]float64 {
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]float64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != name {
+				continue
+			}
+			for _, dp := range m.Data.(metricdata.Sum[float64]).DataPoints {
+				v, _ := dp.Attributes.Value(key)
+				got[v.AsString()] = dp.Value
+			}
+		}
+	}
+	return got
+}
+
+func TestTraceStackSelfMetrics(t *testing.T) {
+	newSpanRecorder(t)
+	reader := newMetricReader(t)
+	if err := RegisterRuntimeMetrics(Metrics); err != nil {
+		t.Fatal(err)
+	}
+	// 计数从进程启动开始累计，只比较前后的差值
+	depthBefore := sumByAttribute(t, reader, "probesdk.trace_stack.pushes", "depth")
+	mismatchBefore := sumByAttribute(t, reader, "probesdk.trace_stack.mismatch", "reason")
+
+	// 泄漏一个条目后结束外层 span
+	func() (err error) {
+		ctx, _ := StartSpan(context.Background(), "outer")
+		defer EndSpan(&err)
+		_, leaked := otel.Tracer(TracerName).Start(ctx, "leaked")
+		OnSpanStart(leaked)
+		return nil
+	}()
+	// 没有 StartSpan 的 EndSpan
+	EndSpan(nil)
+
+	depth := sumByAttribute(t, reader, "probesdk.trace_stack.pushes", "depth")
+	if depth["1"]-depthBefore["1"] != 1 || depth["2"]-depthBefore["2"] != 1 {
+		t.Errorf("expect one push at depth 1 and 2, before %v after %v", depthBefore, depth)
+	}
+	mismatch := sumByAttribute(t, reader, "probesdk.trace_stack.mismatch", "reason")
+	if mismatch[MismatchLeaked]-mismatchBefore[MismatchLeaked] != 1 || mismatch[MismatchUnmatched]-mismatchBefore[MismatchUnmatched] != 1 {
+		t.Errorf("expect one leaked and one unmatched mismatch, before %v after %v", mismatchBefore, mismatch)
+	}
+}
@@ -0,0 +1,142 @@
+package probesdk
+
+import (
+	"context"
+	"go.opentelemetry.io/contrib/bridges/otelslog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/log/global"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"slices"
+)
+
+const (
+	SlogTraceIDKey    = "trace_id"
+	SlogSpanIDKey     = "span_id"
+	SlogTraceFlagsKey = "trace_flags"
+)
+
+type slogConfig struct {
+	mirrorEvents bool
+	eventLevel   slog.Level
+}
+
+type SlogOption func(*slogConfig)
+
+// WithSpanEvents 将级别不低于 level 的日志同时作为事件写入当前活跃的 span
+func WithSpanEvents(level slog.Level) SlogOption {
+	return func(c *slogConfig) {
+		c.mirrorEvents = true
+		c.eventLevel = level
+	}
+}
+
+type slogHandler struct {
+	next slog.Handler
+	cfg  slogConfig
+	// WithGroup 打开的组由这里展开，next 只收到顶层的属性，trace 属性才不会落进组里
+	groups []slogGroup
+}
+
+type slogGroup struct {
+	name  string
+	attrs []slog.Attr
+}
+
+// NewSlogHandler 包装 next，为每条日志加上 trace_id、span_id 和 trace_flags。
+// 日志的 context 中没有 span 时，使用当前 goroutine trace 栈顶的 span
+func NewSlogHandler(next slog.Handler, opts ...SlogOption) slog.Handler {
+	h := &slogHandler{next: next}
+	for _, opt := range opts {
+		opt(&h.cfg)
+	}
+	return h
+}
+
+func (h *slogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level) || h.mirrors(level)
+}
+
+func (h *slogHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	span := trace.SpanFromContext(ctx)
+	if !span.SpanContext().IsValid() {
+		span = ActiveSpan()
+	}
+
+	if h.mirrors(r.Level) && span.IsRecording() {
+		span.AddEvent(r.Message, trace.WithTimestamp(r.Time), trace.WithAttributes(slogEventAttributes(r)...))
+	}
+	if !h.next.Enabled(ctx, r.Level) {
+		return nil
+	}
+	if len(h.groups) > 0 {
+		r = h.nest(r)
+	}
+	if sc := span.SpanContext(); sc.IsValid() {
+		// 让 next（如 OTel 日志桥）也能从 ctx 中拿到 span
+		ctx = trace.ContextWithSpan(ctx, span)
+		r = r.Clone()
+		r.AddAttrs(
+			slog.String(SlogTraceIDKey, sc.TraceID().String()),
+			slog.String(SlogSpanIDKey, sc.SpanID().String()),
+			slog.String(SlogTraceFlagsKey, sc.TraceFlags().String()),
+		)
+	}
+	return h.next.Handle(ctx, r)
+}
+
+// NewOTelSlogHandler 返回将日志通过 InitOpenTelemetryLog 设置的 LoggerProvider 导出的 slog.Handler，
+// name 为 instrumentation scope 名称
+func NewOTelSlogHandler(name string, opts ...SlogOption) slog.Handler {
+	return NewSlogHandler(otelslog.NewHandler(name, otelslog.WithLoggerProvider(global.GetLoggerProvider())), opts...)
+}
+
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(h.groups) == 0 {
+		return &slogHandler{next: h.next.WithAttrs(attrs), cfg: h.cfg}
+	}
+	groups := slices.Clone(h.groups)
+	last := &groups[len(groups)-1]
+	last.attrs = append(slices.Clip(last.attrs), attrs...)
+	return &slogHandler{next: h.next, cfg: h.cfg, groups: groups}
+}
+
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &slogHandler{next: h.next, cfg: h.cfg, groups: append(slices.Clip(h.groups), slogGroup{name: name})}
+}
+
+// 把记录的属性放进 WithGroup 打开的组，没有属性的组按 slog 的约定由 next 忽略
+func (h *slogHandler) nest(r slog.Record) slog.Record {
+	attrs := make([]slog.Attr, 0, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	for i := len(h.groups) - 1; i >= 0; i-- {
+		g := h.groups[i]
+		attrs = []slog.Attr{{Key: g.name, Value: slog.GroupValue(append(slices.Clip(g.attrs), attrs...)...)}}
+	}
+	nested := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	nested.AddAttrs(attrs...)
+	return nested
+}
+
+func (h *slogHandler) mirrors(level slog.Level) bool {
+	return h.cfg.mirrorEvents && level >= h.cfg.eventLevel
+}
+
+func slogEventAttributes(r slog.Record) []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, r.NumAttrs()+1)
+	attrs = append(attrs, attribute.String("log.severity", r.Level.String()))
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, attribute.String(a.Key, a.Value.Resolve().String()))
+		return true
+	})
+	return attrs
+}
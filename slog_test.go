@@ -0,0 +1,120 @@
+package probesdk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"go.opentelemetry.io/otel"
+	"log/slog"
+	"testing"
+)
+
+func newJSONLogger(buf *bytes.Buffer, opts ...SlogOption) *slog.Logger {
+	return slog.New(NewSlogHandler(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelInfo}), opts...))
+}
+
+func decodeLogLine(t *testing.T, buf *bytes.Buffer) map[string]interface{} {
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("invalid log line %q: %v", buf.String(), err)
+	}
+	buf.Reset()
+	return line
+}
+
+func TestSlogHandlerFromGoroutineStack(t *testing.T) {
+	sr := newSpanRecorder(t)
+	var buf bytes.Buffer
+	logger := newJSONLogger(&buf)
+
+	_ = WithSpan("op", func(_ context.Context) error {
+		// 老代码没有传递 ctx
+		logger.Info("hello")
+		return nil
+	})
+
+	s := findSpan(t, sr, "op")
+	line := decodeLogLine(t, &buf)
+	if line[SlogTraceIDKey] != s.SpanContext().TraceID().String() || line[SlogSpanIDKey] != s.SpanContext().SpanID().String() {
+		t.Errorf("expect trace/span id of goroutine stack top, got %v", line)
+	}
+	if line[SlogTraceFlagsKey] != "01" {
+		t.Errorf("expect sampled trace flags, got %v", line[SlogTraceFlagsKey])
+	}
+}
+
+func TestSlogHandlerFromContext(t *testing.T) {
+	newSpanRecorder(t)
+	var buf bytes.Buffer
+	logger := newJSONLogger(&buf)
+
+	ctx, span := otel.Tracer(TracerName).Start(context.Background(), "explicit")
+	defer span.End()
+	_ = WithSpan("stack", func(_ context.Context) error {
+		logger.InfoContext(ctx, "hello")
+		return nil
+	})
+
+	if line := decodeLogLine(t, &buf); line[SlogSpanIDKey] != span.SpanContext().SpanID().String() {
+		t.Errorf("expect span id from context, got %v", line[SlogSpanIDKey])
+	}
+
+	logger.Info("no span")
+	if line := decodeLogLine(t, &buf); line[SlogTraceIDKey] != nil {
+		t.Errorf("expect no trace id without span, got %v", line[SlogTraceIDKey])
+	}
+}
+
+// WithGroup 之后 trace 属性仍在顶层，日志自己的属性留在组里
+func TestSlogHandlerWithGroup(t *testing.T) {
+	newSpanRecorder(t)
+	var buf bytes.Buffer
+	logger := newJSONLogger(&buf).With("app", "shop").WithGroup("req").With("id", 7).WithGroup("user")
+
+	ctx, span := otel.Tracer(TracerName).Start(context.Background(), "explicit")
+	defer span.End()
+	logger.InfoContext(ctx, "hello", "name", "bob")
+
+	line := decodeLogLine(t, &buf)
+	if line[SlogTraceIDKey] != span.SpanContext().TraceID().String() || line[SlogSpanIDKey] != span.SpanContext().SpanID().String() {
+		t.Errorf("expect trace attributes at top level, got %v", line)
+	}
+	req, _ := line["req"].(map[string]interface{})
+	user, _ := req["user"].(map[string]interface{})
+	if line["app"] != "shop" || req["id"] != float64(7) || user["name"] != "bob" {
+		t.Errorf("expect record attributes kept in their groups, got %v", line)
+	}
+
+	// 没有属性的组被忽略
+	logger.InfoContext(ctx, "empty")
+	line = decodeLogLine(t, &buf)
+	if req, _ := line["req"].(map[string]interface{}); req["user"] != nil || req["id"] != float64(7) {
+		t.Errorf("expect empty group omitted, got %v", line)
+	}
+}
+
+func TestSlogHandlerSpanEvents(t *testing.T) {
+	sr := newSpanRecorder(t)
+	var buf bytes.Buffer
+	logger := newJSONLogger(&buf, WithSpanEvents(slog.LevelWarn))
+
+	_ = WithSpan("op", func(_ context.Context) error {
+		logger.Info("ignored")
+		logger.Warn("slow query", "elapsed", "3s")
+		return nil
+	})
+
+	events := findSpan(t, sr, "op").Events()
+	if len(events) != 1 || events[0].Name != "slow query" {
+		t.Fatalf("expect one mirrored event, got %v", events)
+	}
+	found := false
+	for _, kv := range events[0].Attributes {
+		if kv.Key == "elapsed" && kv.Value.AsString() == "3s" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expect record attributes on event, got %v", events[0].Attributes)
+	}
+}
@@ -0,0 +1,82 @@
+package probesdk
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"runtime/debug"
+)
+
+const TracerName = "github.com/gongyuan167/probesdk"
+
+type liveSpan struct {
+	span  trace.Span
+	depth int // 入栈前 trace 栈的深度
+}
+
+// StartSpan 开启一个 span 并压入当前 goroutine 的 trace 栈，ctx 中没有 span 时以栈顶 span 为父 span。
+// 调用方需要紧接着 defer EndSpan(&err)
+func StartSpan(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	ctx, span := otel.Tracer(TracerName).Start(EnsureSpanContext(ctx), name, opts...)
+	pushSpan(span, &stackFrame{live: true})
+	return ctx, span
+}
+
+// EndSpan 结束当前 goroutine 上最近一次由 StartSpan 开启的 span，只能以 defer EndSpan(&err) 的形式调用。
+// *errp 不为 nil 时将 span 标记为 Error；发生 panic 时记录 exception 事件及堆栈，
+// 将 trace 栈弹回 span 开启前的深度并结束 span，然后重新 panic
+func EndSpan(errp *error) {
+	r := recover()
+	ls := popLiveSpan()
+	if ls == nil {
+		recordStackMismatch(MismatchUnmatched, 1)
+		if r != nil {
+			panic(r)
+		}
+		return
+	}
+
+	span := ls.span
+	switch {
+	case r != nil:
+		span.AddEvent(semconv.ExceptionEventName, trace.WithAttributes(
+			semconv.ExceptionType(fmt.Sprintf("%T", r)),
+			semconv.ExceptionMessage(fmt.Sprint(r)),
+			semconv.ExceptionStacktrace(string(debug.Stack())),
+			semconv.ExceptionEscaped(true),
+		))
+		span.SetStatus(codes.Error, fmt.Sprintf("panic: %v", r))
+	case errp != nil && *errp != nil:
+		span.RecordError(*errp)
+		span.SetStatus(codes.Error, (*errp).Error())
+	}
+	popSpanEntry(ls.depth)
+	recordWallClock(span)
+	span.End()
+
+	if r != nil {
+		panic(r)
+	}
+}
+
+// WithSpan 在名为 name 的 span 中执行 fn，fn 返回的错误和 panic 都会记录到 span 上
+func WithSpan(name string, fn func(ctx context.Context) error) (err error) {
+	ctx, _ := StartSpan(context.Background(), name)
+	defer EndSpan(&err)
+	return fn(ctx)
+}
+
+// 从栈顶向下查找第一个由 StartSpan 开启的 span，跳过 panic 时未弹出的 OnSpanStart 条目。
+// 这里只读取，标记随条目出栈时一起清掉
+func popLiveSpan() *liveSpan {
+	data := peekProfLabel()
+	for i := TraceStackDepth() - 1; i >= 0; i-- {
+		if f := stackFrameAt(data, i); f != nil && f.live {
+			return &liveSpan{span: f.span, depth: i}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package probesdk
+
+import (
+	"context"
+	"errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace/noop"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 将全局 TracerProvider 替换为记录所有 span 的 provider，测试结束后恢复
+func newSpanRecorder(t *testing.T) *tracetest.SpanRecorder {
+	sr := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	return sr
+}
+
+func findSpan(t *testing.T, sr *tracetest.SpanRecorder, name string) sdktrace.ReadOnlySpan {
+	for _, s := range sr.Ended() {
+		if s.Name() == name {
+			return s
+		}
+	}
+	t.Fatalf("span %s not found", name)
+	return nil
+}
+
+func hasPanicEvent(s sdktrace.ReadOnlySpan) bool {
+	for _, e := range s.Events() {
+		if e.Name != semconv.ExceptionEventName {
+			continue
+		}
+		for _, kv := range e.Attributes {
+			if kv.Key == semconv.ExceptionStacktraceKey && strings.Contains(kv.Value.AsString(), "panic") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestWithSpanError(t *testing.T) {
+	sr := newSpanRecorder(t)
+	depth := TraceStackDepth()
+
+	want := errors.New("boom")
+	err := WithSpan("op", func(ctx context.Context) error {
+		if TraceStackDepth() != depth+1 {
+			t.Errorf("expect depth %d, got %d", depth+1, TraceStackDepth())
+		}
+		return want
+	})
+	if err != want {
+		t.Errorf("expect %v, got %v", want, err)
+	}
+	if TraceStackDepth() != depth {
+		t.Errorf("expect depth %d, got %d", depth, TraceStackDepth())
+	}
+	if s := findSpan(t, sr, "op"); s.Status().Code != codes.Error {
+		t.Errorf("expect error status, got %v", s.Status())
+	}
+}
+
+func TestWithSpanPanic(t *testing.T) {
+	sr := newSpanRecorder(t)
+	depth := TraceStackDepth()
+
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("expect re-panic with boom, got %v", r)
+			}
+		}()
+		_ = WithSpan("op", func(ctx context.Context) error {
+			panic("boom")
+		})
+	}()
+
+	if TraceStackDepth() != depth {
+		t.Errorf("expect depth %d, got %d", depth, TraceStackDepth())
+	}
+	s := findSpan(t, sr, "op")
+	if s.Status().Code != codes.Error {
+		t.Errorf("expect error status, got %v", s.Status())
+	}
+	if !hasPanicEvent(s) {
+		t.Errorf("expect exception event with stack trace, got %v", s.Events())
+	}
+}
+
+func TestWithSpanNestedPanicRecovered(t *testing.T) {
+	sr := newSpanRecorder(t)
+	depth := TraceStackDepth()
+
+	err := WithSpan("outer", func(ctx context.Context) error {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expect inner panic")
+				}
+			}()
+			_ = WithSpan("inner", func(ctx context.Context) error {
+				return WithSpan("innermost", func(ctx context.Context) error {
+					panic(errors.New("boom"))
+				})
+			})
+		}()
+		if TraceStackDepth() != depth+1 {
+			t.Errorf("expect depth %d after recover, got %d", depth+1, TraceStackDepth())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if TraceStackDepth() != depth {
+		t.Errorf("expect depth %d, got %d", depth, TraceStackDepth())
+	}
+
+	outer := findSpan(t, sr, "outer")
+	inner := findSpan(t, sr, "inner")
+	innermost := findSpan(t, sr, "innermost")
+	if outer.Status().Code == codes.Error {
+		t.Errorf("outer span should not be marked as error")
+	}
+	if !hasPanicEvent(inner) || !hasPanicEvent(innermost) {
+		t.Errorf("expect panic recorded on inner spans")
+	}
+	if inner.Parent().SpanID() != outer.SpanContext().SpanID() {
+		t.Errorf("inner span parent mismatch")
+	}
+	if innermost.Parent().SpanID() != inner.SpanContext().SpanID() {
+		t.Errorf("innermost span parent mismatch")
+	}
+}
+
+func TestEndSpanPopsLeakedEntries(t *testing.T) {
+	sr := newSpanRecorder(t)
+	depth := TraceStackDepth()
+
+	func() {
+		defer func() { recover() }()
+		func() (err error) {
+			ctx, _ := StartSpan(context.Background(), "scoped")
+			defer EndSpan(&err)
+			_, leaked := otel.Tracer(TracerName).Start(ctx, "leaked")
+			OnSpanStart(leaked)
+			panic("boom")
+		}()
+	}()
+
+	if TraceStackDepth() != depth {
+		t.Errorf("expect depth %d, got %d", depth, TraceStackDepth())
+	}
+	if s := findSpan(t, sr, "scoped"); !hasPanicEvent(s) {
+		t.Errorf("expect panic recorded on scoped span")
+	}
+}
+
+// noop provider 下子 span 与父 span 共用 SpanContext，嵌套和并发的 span 都要各自出栈
+func TestWithSpanNoopProvider(t *testing.T) {
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(noop.NewTracerProvider())
+	defer otel.SetTracerProvider(prev)
+
+	nested := func() {
+		depth := TraceStackDepth()
+		WithSpan("outer", func(ctx context.Context) error {
+			return WithSpan("inner", func(ctx context.Context) error {
+				if TraceStackDepth() != depth+2 {
+					t.Errorf("expect depth %d, got %d", depth+2, TraceStackDepth())
+				}
+				return nil
+			})
+		})
+		if TraceStackDepth() != depth {
+			t.Errorf("expect depth %d after nested spans, got %d", depth, TraceStackDepth())
+		}
+	}
+	nested()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			IsolateProfLabel()
+			for j := 0; j < 100; j++ {
+				nested()
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,144 @@
+package sqltrace
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Sanitize 将 SQL 语句中的字符串（包括 PostgreSQL 的 $$...$$、$tag$...$tag$）和数字（包括 0x 十六进制）字面量替换为 ?，
+// 并去掉注释，避免把参数值写入 db.statement。按 MySQL 默认模式把 "..." 也当作字符串字面量，只有 `...` 是标识符，# 开始行注释
+func Sanitize(query string) string {
+	return sanitize(query, false)
+}
+
+// SanitizeANSI 同 Sanitize，但按 ANSI SQL 把 "..." 当作标识符原样保留，用于 PostgreSQL、Oracle、SQLite 等
+func SanitizeANSI(query string) string {
+	return sanitize(query, true)
+}
+
+// 双引号表示标识符的数据库，db.system 取值
+var ansiQuotesSystems = map[string]bool{
+	"postgresql": true,
+	"oracle":     true,
+	"sqlite":     true,
+	"db2":        true,
+	"mssql":      true,
+}
+
+func sanitize(query string, ansiQuotes bool) string {
+	var b strings.Builder
+	b.Grow(len(query))
+	runes := []rune(query)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case c == '-' && i+1 < len(runes) && runes[i+1] == '-', c == '#' && !ansiQuotes:
+			// 行注释里可能有参数值，整段去掉，保留换行
+			for i+1 < len(runes) && runes[i+1] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			j := i + 2
+			for j+1 < len(runes) && !(runes[j] == '*' && runes[j+1] == '/') {
+				j++
+			}
+			i = min(j+1, len(runes)-1)
+			b.WriteRune(' ')
+		case c == '$' && (i == 0 || !isIdentRune(runes[i-1])) && dollarTagEnd(runes, i) > 0:
+			// PostgreSQL 的 dollar-quoted 字符串，找不到结尾时替换到语句末尾
+			tag := runes[i : dollarTagEnd(runes, i)+1]
+			j := i + len(tag)
+			for j+len(tag) <= len(runes) && !slices.Equal(runes[j:j+len(tag)], tag) {
+				j++
+			}
+			i = min(j+len(tag), len(runes)) - 1
+			b.WriteRune('?')
+		case c == '\'' || c == '"' && !ansiQuotes:
+			// 字符串字面量，连续两个引号为转义的引号
+			i++
+			for ; i < len(runes); i++ {
+				if runes[i] == '\\' {
+					i++
+					continue
+				}
+				if runes[i] == c {
+					if i+1 < len(runes) && runes[i+1] == c {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			b.WriteRune('?')
+		case c == '"' || c == '`':
+			// 带引号的标识符原样保留
+			j := i + 1
+			for j < len(runes) && runes[j] != c {
+				j++
+			}
+			if j >= len(runes) {
+				j = len(runes) - 1
+			}
+			b.WriteString(string(runes[i : j+1]))
+			i = j
+		case unicode.IsDigit(c) && (i == 0 || !isIdentRune(runes[i-1])):
+			i = skipNumber(runes, i)
+			b.WriteRune('?')
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// i 处是 $$ 或 $tag$ 形式的开始标记时返回标记结尾 $ 的下标，否则返回 -1。$1 这样的占位符不是标记
+func dollarTagEnd(runes []rune, i int) int {
+	j := i + 1
+	for j < len(runes) && (runes[j] == '_' || unicode.IsLetter(runes[j]) || j > i+1 && unicode.IsDigit(runes[j])) {
+		j++
+	}
+	if j < len(runes) && runes[j] == '$' {
+		return j
+	}
+	return -1
+}
+
+// 返回从 i 开始的数字字面量最后一个字符的下标，支持 0x/0b 前缀、小数和指数
+func skipNumber(runes []rune, i int) int {
+	if runes[i] == '0' && i+1 < len(runes) && strings.ContainsRune("xXbB", runes[i+1]) {
+		i++
+		for i+1 < len(runes) && strings.ContainsRune("0123456789abcdefABCDEF", runes[i+1]) {
+			i++
+		}
+		return i
+	}
+	for i+1 < len(runes) && (unicode.IsDigit(runes[i+1]) || runes[i+1] == '.') {
+		i++
+	}
+	if j := i + 2; j < len(runes) && (runes[i+1] == 'e' || runes[i+1] == 'E') {
+		if runes[j] == '+' || runes[j] == '-' {
+			j++
+		}
+		if j < len(runes) && unicode.IsDigit(runes[j]) {
+			i = j
+			for i+1 < len(runes) && unicode.IsDigit(runes[i+1]) {
+				i++
+			}
+		}
+	}
+	return i
+}
+
+// Operation 返回语句的第一个关键字，如 SELECT、INSERT
+func Operation(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToUpper(fields[0])
+}
+
+func isIdentRune(c rune) bool {
+	return c == '_' || c == '$' || c == '@' || c == ':' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
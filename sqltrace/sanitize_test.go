@@ -0,0 +1,67 @@
+package sqltrace
+
+import "testing"
+
+func TestSanitize(t *testing.T) {
+	cases := map[string]string{
+		"SELECT * FROM t WHERE id = 42":                     "SELECT * FROM t WHERE id = ?",
+		"SELECT * FROM t WHERE name = 'it''s' AND x=1.5":    "SELECT * FROM t WHERE name = ? AND x=?",
+		"SELECT * FROM t2 WHERE id = $1":                    "SELECT * FROM t2 WHERE id = $1",
+		`SELECT ` + "`col1`" + ` FROM t WHERE a = 'x\'y'`:   `SELECT ` + "`col1`" + ` FROM t WHERE a = ?`,
+		`SELECT * FROM t WHERE a = "secret" AND b = "x""y"`: `SELECT * FROM t WHERE a = ? AND b = ?`,
+		"INSERT INTO t VALUES (?, ?)":                       "INSERT INTO t VALUES (?, ?)",
+	}
+	for in, want := range cases {
+		if got := Sanitize(in); got != want {
+			t.Errorf("Sanitize(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSanitizeLiteralForms(t *testing.T) {
+	cases := []struct {
+		name, in, want string
+		ansi           bool
+	}{
+		{"dollar quoted", "SELECT $$secret$$, 1", "SELECT ?, ?", true},
+		{"tagged dollar quoted", "SELECT $fn$ it's $$ secret $fn$ FROM t", "SELECT ? FROM t", true},
+		{"unterminated dollar quoted", "SELECT $a$secret", "SELECT ?", true},
+		{"placeholder kept", "SELECT * FROM t WHERE id = $1 AND b = $2", "SELECT * FROM t WHERE id = $1 AND b = $2", true},
+		{"identifier with dollar", "SELECT a$b$ FROM t", "SELECT a$b$ FROM t", true},
+		{"line comment", "SELECT 1 -- token 'abc'\nFROM t", "SELECT ? \nFROM t", false},
+		{"hash comment", "SELECT 1 # password=hunter2", "SELECT ? ", false},
+		{"block comment", "SELECT /* user=bob, id=7 */ a FROM t", "SELECT   a FROM t", true},
+		{"unterminated block comment", "SELECT a /* secret", "SELECT a  ", true},
+		{"hex literal", "SELECT * FROM t WHERE k = 0xDEADBEEF", "SELECT * FROM t WHERE k = ?", false},
+		{"binary literal", "SELECT 0b1010", "SELECT ?", false},
+		{"exponent", "SELECT 1.5e-10, 2E3", "SELECT ?, ?", false},
+	}
+	for _, c := range cases {
+		got := Sanitize(c.in)
+		if c.ansi {
+			got = SanitizeANSI(c.in)
+		}
+		if got != c.want {
+			t.Errorf("%s: sanitize(%q) = %q, want %q", c.name, c.in, got, c.want)
+		}
+	}
+}
+
+func TestSanitizeANSI(t *testing.T) {
+	in := `SELECT "col1" FROM t WHERE a = 'secret'`
+	if got := SanitizeANSI(in); got != `SELECT "col1" FROM t WHERE a = ?` {
+		t.Errorf("expect double-quoted identifier kept, got %q", got)
+	}
+	if got := Sanitize(in); got != `SELECT ? FROM t WHERE a = ?` {
+		t.Errorf("expect double-quoted text treated as literal, got %q", got)
+	}
+}
+
+func TestOperation(t *testing.T) {
+	if op := Operation("  select 1"); op != "SELECT" {
+		t.Errorf("expect SELECT, got %q", op)
+	}
+	if op := Operation(""); op != "" {
+		t.Errorf("expect empty operation, got %q", op)
+	}
+}
@@ -0,0 +1,473 @@
+// Package sqltrace 包装 database/sql 驱动，为 Query/Exec/Prepare/Begin/Commit/Rollback 生成 CLIENT span。
+// 调用方没有传递 context 时，以当前 goroutine trace 栈顶的 span 为父 span
+package sqltrace
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"github.com/gongyuan167/probesdk"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
+	"time"
+)
+
+type config struct {
+	system        string
+	dbName        string
+	meterProvider metric.MeterProvider
+}
+
+type Option func(*config)
+
+// WithDBSystem 设置 db.system 属性，如 mysql、postgresql
+func WithDBSystem(system string) Option {
+	return func(c *config) {
+		c.system = system
+	}
+}
+
+// WithDBName 设置 db.name 属性
+func WithDBName(name string) Option {
+	return func(c *config) {
+		c.dbName = name
+	}
+}
+
+// WithMeterProvider 设置记录 db.client.operation.duration 的 MeterProvider，默认记录在 probesdk.Metrics 注册表中
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return func(c *config) {
+		c.meterProvider = mp
+	}
+}
+
+var registerMu sync.Mutex
+
+// Register 注册一个包装了 driverName 驱动的新驱动，返回新驱动的名字，可直接用于 sql.Open
+func Register(driverName string, opts ...Option) (string, error) {
+	db, err := sql.Open(driverName, "")
+	if err != nil {
+		return "", err
+	}
+	d := db.Driver()
+	_ = db.Close()
+
+	registerMu.Lock()
+	defer registerMu.Unlock()
+	for i := 0; ; i++ {
+		name := fmt.Sprintf("%s-sqltrace-%d", driverName, i)
+		if !registered(name) {
+			sql.Register(name, Wrap(d, opts...))
+			return name, nil
+		}
+	}
+}
+
+func registered(name string) bool {
+	for _, d := range sql.Drivers() {
+		if d == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Wrap 返回包装了 d 的驱动
+func Wrap(d driver.Driver, opts ...Option) driver.Driver {
+	return &tracedDriver{Driver: d, t: newTracer(opts)}
+}
+
+// WrapConnector 返回包装了 c 的 Connector，用于 sql.OpenDB
+func WrapConnector(c driver.Connector, opts ...Option) driver.Connector {
+	t := newTracer(opts)
+	return &tracedConnector{Connector: c, driver: &tracedDriver{Driver: c.Driver(), t: t}, t: t}
+}
+
+type tracer struct {
+	cfg      config
+	duration *probesdk.Histogram
+}
+
+func newTracer(opts []Option) *tracer {
+	cfg := config{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	// 默认使用 probe 的指标注册表，多次 Register 共用同一个句柄
+	registry := probesdk.Metrics
+	if cfg.meterProvider != nil || registry == nil {
+		mp := cfg.meterProvider
+		if mp == nil {
+			mp = otel.GetMeterProvider()
+		}
+		registry = probesdk.NewMetricRegistry(mp.Meter(probesdk.TracerName))
+	}
+	duration, err := registry.Histogram(probesdk.InstrumentSpec{
+		Name:          "db.client.operation.duration",
+		Description:   "Duration of database client operations.",
+		Unit:          "s",
+		AttributeKeys: []attribute.Key{semconv.DBSystemKey, semconv.DBNameKey, semconv.DBOperationKey},
+	})
+	if err != nil {
+		otel.Handle(err)
+	}
+	return &tracer{cfg: cfg, duration: duration}
+}
+
+func (t *tracer) attributes(method, query string) []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, 4)
+	if t.cfg.system != "" {
+		attrs = append(attrs, semconv.DBSystemKey.String(t.cfg.system))
+	}
+	if t.cfg.dbName != "" {
+		attrs = append(attrs, semconv.DBName(t.cfg.dbName))
+	}
+	if op := Operation(query); op != "" {
+		attrs = append(attrs, semconv.DBOperation(op))
+	} else {
+		attrs = append(attrs, semconv.DBOperation(method))
+	}
+	return attrs
+}
+
+// 调用 fn 并用 span 包裹。span 在调用 fn 之前开启并压入当前 goroutine 的 trace 栈，fn 中驱动自己的埋点和嵌套调用以它为父 span。
+// fn 返回 driver.ErrSkip 时 database/sql 会换一种方式重做，span 照常结束，但不标记错误也不记录耗时
+func (t *tracer) do(ctx context.Context, method, query string, fn func(ctx context.Context) error) error {
+	start := time.Now()
+	attrs := t.attributes(method, query)
+	spanAttrs := attrs
+	if query != "" {
+		spanAttrs = append(spanAttrs, semconv.DBStatement(t.sanitize(query)))
+	}
+	name := method
+	if op := Operation(query); op != "" {
+		name = op
+	}
+	ctx, span := otel.Tracer(probesdk.TracerName).Start(
+		probesdk.EnsureSpanContext(ctx),
+		name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(spanAttrs...),
+	)
+	depth := probesdk.TraceStackDepth()
+	probesdk.OnSpanStart(span)
+	defer probesdk.PopTraceContextTo(depth)
+	defer span.End()
+
+	err := fn(ctx)
+	switch {
+	case err == driver.ErrSkip:
+		return err
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	t.duration.Record(ctx, time.Since(start).Seconds(), attrs...)
+	return err
+}
+
+func (t *tracer) sanitize(query string) string {
+	if ansiQuotesSystems[t.cfg.system] {
+		return SanitizeANSI(query)
+	}
+	return Sanitize(query)
+}
+
+type tracedDriver struct {
+	driver.Driver
+	t *tracer
+}
+
+func (d *tracedDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedConn{Conn: c, t: d.t}, nil
+}
+
+func (d *tracedDriver) OpenConnector(name string) (driver.Connector, error) {
+	if dc, ok := d.Driver.(driver.DriverContext); ok {
+		c, err := dc.OpenConnector(name)
+		if err != nil {
+			return nil, err
+		}
+		return &tracedConnector{Connector: c, driver: d, t: d.t}, nil
+	}
+	return &tracedConnector{Connector: dsnConnector{name: name, driver: d.Driver}, driver: d, t: d.t}, nil
+}
+
+type dsnConnector struct {
+	name   string
+	driver driver.Driver
+}
+
+func (c dsnConnector) Connect(_ context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.name)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+type tracedConnector struct {
+	driver.Connector
+	driver driver.Driver
+	t      *tracer
+}
+
+func (c *tracedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedConn{Conn: conn, t: c.t}, nil
+}
+
+func (c *tracedConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+type tracedConn struct {
+	driver.Conn
+	t *tracer
+}
+
+var (
+	_ driver.ConnPrepareContext = (*tracedConn)(nil)
+	_ driver.ConnBeginTx        = (*tracedConn)(nil)
+	_ driver.ExecerContext      = (*tracedConn)(nil)
+	_ driver.QueryerContext     = (*tracedConn)(nil)
+	_ driver.Pinger             = (*tracedConn)(nil)
+	_ driver.SessionResetter    = (*tracedConn)(nil)
+	_ driver.Validator          = (*tracedConn)(nil)
+	_ driver.NamedValueChecker  = (*tracedConn)(nil)
+)
+
+func (c *tracedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *tracedConn) PrepareContext(ctx context.Context, query string) (stmt driver.Stmt, err error) {
+	err = c.t.do(ctx, "Prepare", query, func(ctx context.Context) error {
+		if cp, ok := c.Conn.(driver.ConnPrepareContext); ok {
+			stmt, err = cp.PrepareContext(ctx, query)
+		} else {
+			stmt, err = c.Conn.Prepare(query)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return wrapStmt(stmt, c.Conn, query, c.t), nil
+}
+
+func (c *tracedConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *tracedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (tx driver.Tx, err error) {
+	err = c.t.do(ctx, "Begin", "", func(ctx context.Context) error {
+		tx, err = beginTx(ctx, c.Conn, opts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &tracedTx{Tx: tx, ctx: ctx, t: c.t}, nil
+}
+
+// 同 database/sql 的 ctxDriverBegin：驱动不支持 BeginTx 时拒绝非默认的隔离级别和只读事务，不能悄悄降级为普通事务
+func beginTx(ctx context.Context, conn driver.Conn, opts driver.TxOptions) (driver.Tx, error) {
+	if cb, ok := conn.(driver.ConnBeginTx); ok {
+		return cb.BeginTx(ctx, opts)
+	}
+	if opts.Isolation != driver.IsolationLevel(sql.LevelDefault) {
+		return nil, errors.New("sqltrace: driver does not support non-default isolation level")
+	}
+	if opts.ReadOnly {
+		return nil, errors.New("sqltrace: driver does not support read-only transactions")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	tx, err := conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case <-ctx.Done():
+		tx.Rollback()
+		return nil, ctx.Err()
+	default:
+	}
+	return tx, nil
+}
+
+func (c *tracedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (res driver.Result, err error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	err = c.t.do(ctx, "Exec", query, func(ctx context.Context) error {
+		res, err = execer.ExecContext(ctx, query, args)
+		return err
+	})
+	return res, err
+}
+
+func (c *tracedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (rows driver.Rows, err error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	err = c.t.do(ctx, "Query", query, func(ctx context.Context) error {
+		rows, err = queryer.QueryContext(ctx, query, args)
+		return err
+	})
+	return rows, err
+}
+
+func (c *tracedConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *tracedConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *tracedConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *tracedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if nc, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+type tracedStmt struct {
+	driver.Stmt
+	conn  driver.Conn
+	query string
+	t     *tracer
+}
+
+var (
+	_ driver.StmtExecContext   = (*tracedStmt)(nil)
+	_ driver.StmtQueryContext  = (*tracedStmt)(nil)
+	_ driver.NamedValueChecker = (*tracedStmt)(nil)
+	_ driver.ColumnConverter   = columnConverterStmt{}
+)
+
+// database/sql 只在 stmt 实现了 ColumnConverter 时才按列转换参数，包装后的 stmt 要与原 stmt 一致
+func wrapStmt(stmt driver.Stmt, conn driver.Conn, query string, t *tracer) driver.Stmt {
+	s := &tracedStmt{Stmt: stmt, conn: conn, query: query, t: t}
+	if _, ok := stmt.(driver.ColumnConverter); ok {
+		return columnConverterStmt{s}
+	}
+	return s
+}
+
+type columnConverterStmt struct {
+	*tracedStmt
+}
+
+func (s columnConverterStmt) ColumnConverter(idx int) driver.ValueConverter {
+	return s.Stmt.(driver.ColumnConverter).ColumnConverter(idx)
+}
+
+// database/sql 先用 stmt 的 NamedValueChecker，没有时才用 conn 的
+func (s *tracedStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if nc, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(nv)
+	}
+	if nc, ok := s.conn.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func (s *tracedStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), toNamedValues(args))
+}
+
+func (s *tracedStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), toNamedValues(args))
+}
+
+func (s *tracedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (res driver.Result, err error) {
+	err = s.t.do(ctx, "Exec", s.query, func(ctx context.Context) error {
+		if se, ok := s.Stmt.(driver.StmtExecContext); ok {
+			res, err = se.ExecContext(ctx, args)
+		} else {
+			res, err = s.Stmt.Exec(toValues(args))
+		}
+		return err
+	})
+	return res, err
+}
+
+func (s *tracedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (rows driver.Rows, err error) {
+	err = s.t.do(ctx, "Query", s.query, func(ctx context.Context) error {
+		if sq, ok := s.Stmt.(driver.StmtQueryContext); ok {
+			rows, err = sq.QueryContext(ctx, args)
+		} else {
+			rows, err = s.Stmt.Query(toValues(args))
+		}
+		return err
+	})
+	return rows, err
+}
+
+type tracedTx struct {
+	driver.Tx
+	ctx context.Context
+	t   *tracer
+}
+
+func (tx *tracedTx) Commit() error {
+	return tx.t.do(tx.ctx, "Commit", "", func(context.Context) error {
+		return tx.Tx.Commit()
+	})
+}
+
+func (tx *tracedTx) Rollback() error {
+	return tx.t.do(tx.ctx, "Rollback", "", func(context.Context) error {
+		return tx.Tx.Rollback()
+	})
+}
+
+func toNamedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return named
+}
+
+func toValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for i, nv := range args {
+		values[i] = nv.Value
+	}
+	return values
+}
@@ -0,0 +1,294 @@
+package sqltrace
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"github.com/gongyuan167/probesdk"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"io"
+	"strings"
+	"testing"
+)
+
+// 内存中的假驱动，除了语句 FAIL 之外所有操作都直接成功
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return &fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	if query == "CONVERT" {
+		return &convertStmt{fakeStmt{query: query}}, nil
+	}
+	return &fakeStmt{query: query}, nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	switch query {
+	case "FAIL":
+		return nil, errors.New("fake failure")
+	case "SKIP", "CONVERT":
+		return nil, driver.ErrSkip
+	case "NESTED":
+		// 驱动自己的埋点，从 ctx 和 goroutine trace 栈都应能找到 db span
+		_, span := otel.Tracer("driver").Start(ctx, "driver")
+		span.End()
+		_, span = probesdk.StartSpan(context.Background(), "stack")
+		probesdk.EndSpan(nil)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	return &fakeRows{}, nil
+}
+
+type fakeStmt struct{ query string }
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(1), nil
+}
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) { return &fakeRows{}, nil }
+
+// 实现了 ColumnConverter 的 stmt，参数要先经它转换
+type convertStmt struct{ fakeStmt }
+
+func (s *convertStmt) NumInput() int                             { return 1 }
+func (s *convertStmt) ColumnConverter(int) driver.ValueConverter { return upperConverter{} }
+
+func (s *convertStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if args[0] != "BOB" {
+		return nil, fmt.Errorf("expect converted argument, got %v", args[0])
+	}
+	return driver.RowsAffected(1), nil
+}
+
+type upperConverter struct{}
+
+func (upperConverter) ConvertValue(v any) (driver.Value, error) {
+	return strings.ToUpper(fmt.Sprint(v)), nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct{}
+
+func (r *fakeRows) Columns() []string              { return []string{"id"} }
+func (r *fakeRows) Close() error                   { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error { return io.EOF }
+
+func init() {
+	sql.Register("fake", fakeDriver{})
+}
+
+func setup(t *testing.T) (*sql.DB, *tracetest.SpanRecorder, *sdkmetric.ManualReader) {
+	sr := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	reader := sdkmetric.NewManualReader()
+	name, err := Register("fake",
+		WithDBSystem("fakedb"),
+		WithDBName("test"),
+		WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, sr, reader
+}
+
+func spanByName(t *testing.T, sr *tracetest.SpanRecorder, name string) sdktrace.ReadOnlySpan {
+	for _, s := range sr.Ended() {
+		if s.Name() == name {
+			return s
+		}
+	}
+	t.Fatalf("span %s not found", name)
+	return nil
+}
+
+func TestParentFromGoroutineStack(t *testing.T) {
+	db, sr, reader := setup(t)
+
+	err := probesdk.WithSpan("dao", func(_ context.Context) error {
+		// 老代码没有传递 ctx
+		_, err := db.Exec("UPDATE users SET name = 'bob' WHERE id = 42")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dao := spanByName(t, sr, "dao")
+	update := spanByName(t, sr, "UPDATE")
+	if update.Parent().SpanID() != dao.SpanContext().SpanID() {
+		t.Errorf("expect db span parented from goroutine stack")
+	}
+	attrs := attribute.NewSet(update.Attributes()...)
+	if v, _ := attrs.Value(semconv.DBStatementKey); v.AsString() != "UPDATE users SET name = ? WHERE id = ?" {
+		t.Errorf("expect sanitized statement, got %q", v.AsString())
+	}
+	if v, _ := attrs.Value(semconv.DBSystemKey); v.AsString() != "fakedb" {
+		t.Errorf("expect db.system attribute, got %v", v)
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	h := rm.ScopeMetrics[0].Metrics[0].Data.(metricdata.Histogram[float64])
+	if len(h.DataPoints) != 1 || h.DataPoints[0].Count != 1 {
+		t.Errorf("expect one db operation recorded, got %+v", h.DataPoints)
+	}
+}
+
+func TestParentFromContext(t *testing.T) {
+	db, sr, _ := setup(t)
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "parent")
+	rows, err := db.QueryContext(ctx, "SELECT id FROM users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	parent.End()
+
+	if spanByName(t, sr, "SELECT").Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("expect db span parented from context")
+	}
+}
+
+func TestPrepareAndTransaction(t *testing.T) {
+	db, sr, _ := setup(t)
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := tx.Prepare("INSERT INTO users VALUES (?)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stmt.Exec(1); err != nil {
+		t.Fatal(err)
+	}
+	stmt.Close()
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, s := range sr.Ended() {
+		names = append(names, s.Name())
+	}
+	want := []string{"Begin", "INSERT", "INSERT", "Commit"}
+	if len(names) != len(want) {
+		t.Fatalf("expect spans %v, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("expect spans %v, got %v", want, names)
+		}
+	}
+}
+
+func TestExecError(t *testing.T) {
+	db, sr, _ := setup(t)
+
+	if _, err := db.Exec("FAIL"); err == nil {
+		t.Fatal("expect error")
+	}
+	if s := spanByName(t, sr, "FAIL"); s.Status().Code != codes.Error {
+		t.Errorf("expect error status, got %v", s.Status())
+	}
+}
+
+// driver.ErrSkip 不是错误，跳过的调用不标记 Error
+func TestErrSkipNotMarkedAsError(t *testing.T) {
+	db, sr, _ := setup(t)
+	if _, err := db.Exec("SKIP"); err != nil {
+		t.Fatal(err)
+	}
+	if started, ended := len(sr.Started()), len(sr.Ended()); started != ended {
+		t.Errorf("expect every started span ended, started %d ended %d", started, ended)
+	}
+	for _, s := range sr.Ended() {
+		if s.Status().Code == codes.Error {
+			t.Errorf("expect no span for skipped call, got %s with error", s.Name())
+		}
+	}
+}
+
+func TestDurationRegisteredInProbeRegistry(t *testing.T) {
+	if _, err := Register("fake"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := probesdk.Metrics.Instruments()["db.client.operation.duration"]; !ok {
+		t.Errorf("expect db.client.operation.duration declared in probesdk.Metrics")
+	}
+}
+
+func TestSpanStartedBeforeDriverCall(t *testing.T) {
+	db, sr, _ := setup(t)
+	if _, err := db.Exec("NESTED"); err != nil {
+		t.Fatal(err)
+	}
+	nested := spanByName(t, sr, "NESTED").SpanContext().SpanID()
+	for _, name := range []string{"driver", "stack"} {
+		if spanByName(t, sr, name).Parent().SpanID() != nested {
+			t.Errorf("expect %s span parented by db span", name)
+		}
+	}
+	if d := probesdk.TraceStackDepth(); d != 0 {
+		t.Errorf("expect trace stack popped after call, got depth %d", d)
+	}
+}
+
+func TestColumnConverterForwarded(t *testing.T) {
+	db, _, _ := setup(t)
+	if _, err := db.Exec("CONVERT", "bob"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 驱动不支持 BeginTx 时与 database/sql 一致，拒绝不能满足的事务选项
+func TestBeginTxUnsupportedOptions(t *testing.T) {
+	db, _, _ := setup(t)
+	for _, opts := range []*sql.TxOptions{{Isolation: sql.LevelSerializable}, {ReadOnly: true}} {
+		if tx, err := db.BeginTx(context.Background(), opts); err == nil {
+			tx.Rollback()
+			t.Errorf("expect error for %+v", opts)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := db.BeginTx(ctx, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("expect context canceled, got %v", err)
+	}
+}
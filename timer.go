@@ -0,0 +1,94 @@
+package probesdk
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/trace"
+	"runtime/pprof"
+	"sync"
+	"time"
+)
+
+// WrapCallback 记录注册时当前 goroutine trace 栈顶的 span，返回的函数执行 fn 期间把该 span 压入执行者的 trace 栈，
+// 使延迟执行的回调（time.AfterFunc、回调注册、sync.Once 等）出现在注册者的 trace 中。注册时栈为空则原样返回 fn
+func WrapCallback(fn func()) func() {
+	span := ActiveSpan()
+	if !span.SpanContext().IsValid() {
+		return fn
+	}
+	return func() {
+		depth := TraceStackDepth()
+		OnSpanStart(span)
+		defer popSpanEntry(depth)
+		fn()
+	}
+}
+
+// AfterFunc 同 time.AfterFunc，f 在当前 goroutine trace 栈顶的 span 下执行
+func AfterFunc(d time.Duration, f func()) *time.Timer {
+	return time.AfterFunc(d, WrapCallback(f))
+}
+
+// Ticker 周期性执行任务，每次执行开启一个名为 name 的新根 span，创建者的 span 作为 link
+type Ticker struct {
+	ticker *time.Ticker
+	name   string
+	fn     func(ctx context.Context) error
+	link   trace.SpanContext
+	stop   chan struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+// NewTicker 每隔 d 在后台 goroutine 上执行一次 fn，fn 返回的错误和 panic 记录在该次的 span 上，panic 不会中断后续执行
+func NewTicker(d time.Duration, name string, fn func(ctx context.Context) error) *Ticker {
+	t := &Ticker{
+		ticker: time.NewTicker(d),
+		name:   name,
+		fn:     fn,
+		link:   ActiveSpan().SpanContext(),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+func (t *Ticker) run() {
+	defer close(t.done)
+	// 清掉继承自创建者的标签，每次执行都是新的根 span
+	pprof.SetGoroutineLabels(context.Background())
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-t.ticker.C:
+			t.tick()
+		}
+	}
+}
+
+func (t *Ticker) tick() {
+	defer func() { recover() }()
+	opts := []trace.SpanStartOption{trace.WithNewRoot()}
+	if t.link.IsValid() {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: t.link}))
+	}
+	var err error
+	ctx, _ := StartSpan(context.Background(), t.name, opts...)
+	defer EndSpan(&err)
+	err = t.fn(ctx)
+}
+
+// Reset 修改执行间隔
+func (t *Ticker) Reset(d time.Duration) {
+	t.ticker.Reset(d)
+}
+
+// Stop 停止 Ticker 并等待正在执行的 fn 返回，可重复调用，但不能在 fn 中调用
+func (t *Ticker) Stop() {
+	t.once.Do(func() {
+		t.ticker.Stop()
+		close(t.stop)
+	})
+	<-t.done
+}
@@ -0,0 +1,124 @@
+package probesdk
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAfterFunc(t *testing.T) {
+	sr := newSpanRecorder(t)
+	_, origin := StartSpan(context.Background(), "register")
+	defer EndSpan(nil)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	AfterFunc(time.Millisecond, func() {
+		defer wg.Done()
+		WithSpan("delayed", func(ctx context.Context) error { return nil })
+		if TraceStackDepth() != 1 {
+			t.Errorf("expect only the registering span on callback stack, got %d", TraceStackDepth())
+		}
+	})
+	wg.Wait()
+
+	if findSpan(t, sr, "delayed").Parent().SpanID() != origin.SpanContext().SpanID() {
+		t.Errorf("expect delayed span child of registering span")
+	}
+	if !ActiveSpan().IsRecording() {
+		t.Errorf("expect registering span still active")
+	}
+}
+
+func TestWrapCallbackWithoutSpan(t *testing.T) {
+	called := false
+	WrapCallback(func() { called = true })()
+	if !called {
+		t.Errorf("expect callback called")
+	}
+}
+
+func TestTicker(t *testing.T) {
+	sr := newSpanRecorder(t)
+	_, origin := StartSpan(context.Background(), "schedule")
+
+	var mu sync.Mutex
+	ticks := 0
+	ticker := NewTicker(2*time.Millisecond, "tick", func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		ticks++
+		switch ticks {
+		case 1:
+			panic("boom")
+		case 2:
+			return errors.New("failed")
+		}
+		return nil
+	})
+	EndSpan(nil)
+	for {
+		mu.Lock()
+		n := ticks
+		mu.Unlock()
+		if n >= 3 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	ticker.Stop()
+	ticker.Stop()
+
+	var spans int
+	for _, s := range sr.Ended() {
+		if s.Name() != "tick" {
+			continue
+		}
+		spans++
+		if s.Parent().IsValid() {
+			t.Errorf("expect tick span to be a root span")
+		}
+		if len(s.Links()) != 1 || s.Links()[0].SpanContext.SpanID() != origin.SpanContext().SpanID() {
+			t.Errorf("expect tick span linked to creator span, got %v", s.Links())
+		}
+	}
+	if spans < 3 {
+		t.Errorf("expect at least 3 tick spans, got %d", spans)
+	}
+	first := findSpan(t, sr, "tick")
+	if !hasPanicEvent(first) {
+		t.Errorf("expect panic recorded on first tick")
+	}
+}
+
+// 回调反复压栈、出栈注册者的 span 时，注册者 goroutine 上始终能找回它
+func TestWrapCallbackActiveSpanRace(t *testing.T) {
+	newSpanRecorder(t)
+	_, owner := StartSpan(context.Background(), "owner")
+	defer EndSpan(nil)
+
+	cb := WrapCallback(func() {
+		if ActiveSpan() != owner {
+			t.Errorf("expect registering span active in callback")
+		}
+	})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			cb()
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if ActiveSpan() != owner {
+			t.Fatalf("expect registering span active while callbacks pop it")
+		}
+	}
+}
@@ -3,113 +3,210 @@ package probesdk
 import (
 	"context"
 	"fmt"
+	"github.com/gongyuan167/probesdk/labels"
 	"go.opentelemetry.io/otel/trace"
 	"strconv"
-	"sync"
+	"sync/atomic"
+	"unsafe"
 )
 
-const GRTTraceContextPrefix = "GRTTraceContextKey/"
-const GRTTraceContextLen = GRTTraceContextPrefix + "Idx"
+// trace 栈标签的编码在无副作用的 labels 包中，这里保留原有的导出名
+const GRTTraceContextPrefix = labels.GRTTraceContextPrefix
+const GRTTraceContextLen = labels.GRTTraceContextLen
 
-const TraceIDSize = 16
-const SpanIDSize = 8
-const TraceFlagsSize = 1
-const RemoteFlagSize = 1 // use one byte to store boolean
+const TraceIDSize = labels.TraceIDSize
+const SpanIDSize = labels.SpanIDSize
+const TraceFlagsSize = labels.TraceFlagsSize
+const RemoteFlagSize = labels.RemoteFlagSize
 
-const TraceIDStart = 0
-const SpanIDStart = TraceIDStart + TraceIDSize
-const TraceFlagsStart = SpanIDStart + SpanIDSize
-const RemoteFlagStart = TraceFlagsStart + TraceFlagsSize
-const TraceStatesStart = RemoteFlagStart + RemoteFlagSize
+const TraceIDStart = labels.TraceIDStart
+const SpanIDStart = labels.SpanIDStart
+const TraceFlagsStart = labels.TraceFlagsStart
+const RemoteFlagStart = labels.RemoteFlagStart
+const TraceStatesStart = labels.TraceStatesStart
 
-const ByteBufferStartSize = TraceStatesStart
+const ByteBufferStartSize = labels.ByteBufferStartSize
 
-// 创建内存池
-var bytePool = &sync.Pool{
-	New: func() interface{} {
-		// 每次新建一个长度为26的字节切片
-		return make([]byte, ByteBufferStartSize)
-	},
+// DecodeTraceContext 解析 EncodeTraceContext 编码的标签值，非法时返回空的 SpanContext
+func DecodeTraceContext(data string) trace.SpanContext {
+	return labels.DecodeTraceContext(data)
 }
 
-func DecodeTraceContext(data string) trace.SpanContext {
-	traceState, err := trace.ParseTraceState(data[TraceStatesStart:])
-	isRemote := false
-	if data[RemoteFlagStart] != 0 {
-		isRemote = true
-	}
-	if err != nil {
-		panic(err)
+// ParseTraceContext 同 DecodeTraceContext，长度不足或 tracestate 非法时返回错误
+func ParseTraceContext(data string) (trace.SpanContext, error) {
+	return labels.ParseTraceContext(data)
+}
+
+func EncodeTraceContext(ctx trace.SpanContext) string {
+	return labels.EncodeTraceContext(ctx)
+}
+
+// 每个条目旁记一个 label 引用该次压栈的 stackFrame，值的字节就是 stackFrame 的 tag 字段。
+// stackFrame 只被 label map 引用，goroutine 退出而没有出栈时随它的 label 一起回收，不会留在全局表里
+const GRTStackFramePrefix = GRTTraceContextPrefix + "Frame/"
+
+// tag 的内容，用于确认 label 值确实指向 stackFrame
+const stackFrameTag = "f"
+
+type stackFrame struct {
+	tag    [len(stackFrameTag)]byte // 必须是第一个字段
+	span   trace.Span
+	live   bool           // 由 StartSpan 压入，由 EndSpan 结束
+	recv   chanRecvMode   // 由 Chan 的 Recv 压入
+	labels unsafe.Pointer // 压栈后装上的 label map
+	prev   unsafe.Pointer // 压栈前的 label map
+}
+
+// 写入 label 的值，指向 f 自身，label map 存活时 f 不会被回收
+func (f *stackFrame) ref() string {
+	return unsafe.String(&f.tag[0], len(f.tag))
+}
+
+func stackFrameKey(idx int) string {
+	if idx < len(stackFrameKeys) {
+		return stackFrameKeys[idx]
 	}
-	config := trace.SpanContextConfig{
-		TraceState: traceState,
-		Remote:     isRemote,
+	return GRTStackFramePrefix + strconv.Itoa(idx)
+}
+
+// 常见深度的 label key 预先拼好，避免每次压栈分配
+var stackFrameKeys = func() [32]string {
+	var keys [32]string
+	for i := range keys {
+		keys[i] = GRTStackFramePrefix + strconv.Itoa(i)
 	}
-	copy(config.TraceID[:], data[TraceIDStart:SpanIDStart])
-	copy(config.SpanID[:], data[SpanIDStart:TraceFlagsStart])
-	config.TraceFlags = trace.TraceFlags(data[TraceFlagsStart])
-	return trace.NewSpanContext(config)
+	return keys
+}()
 
+// 返回 trace 栈第 idx 个条目的 stackFrame，没有时返回 nil
+func stackFrameAt(data map[string]string, idx int) *stackFrame {
+	v, ok := data[stackFrameKey(idx)]
+	if !ok || v != stackFrameTag {
+		return nil
+	}
+	return (*stackFrame)(unsafe.Pointer(unsafe.StringData(v)))
 }
 
-func EncodeTraceContext(ctx trace.SpanContext) string {
-	traceStateStr := ctx.TraceState().String()
-	bytes := bytePool.Get().([]byte)
-	defer bytePool.Put(bytes)
-	// bytes := make([]byte, TraceStatesStart+len(traceStateStr))
-	traceID := ctx.TraceID()
-	spanID := ctx.SpanID()
-	copy(bytes[TraceIDStart:SpanIDStart], traceID[:])
-	copy(bytes[SpanIDStart:TraceFlagsStart], spanID[:])
-	bytes[TraceFlagsStart] = byte(ctx.TraceFlags())
-	if ctx.IsRemote() {
-		bytes[RemoteFlagStart] = 1
-	}
-	// copy(bytes[TraceStatesStart:], traceStateStr)
-	if len(traceStateStr) == 0 {
-		return string(bytes)
+// 返回 trace 栈第 idx 个条目压入的 span，条目不是由 OnSpanStart 压入时返回 false
+func stackSpan(data map[string]string, idx int) (trace.Span, bool) {
+	if f := stackFrameAt(data, idx); f != nil && f.span != nil {
+		return f.span, true
 	}
-	return string(bytes) + traceStateStr
+	return nil, false
 }
 
 func getTargetKey(idx int) string {
-	return strconv.Itoa(idx)
+	return labels.StackKey(idx)
 }
 
+// PopTraceContext 弹出栈顶条目。当前的 label map 就是该条目压栈时装上的，直接换回压栈前的 map，
+// 否则（期间装过别的 label）换上删掉该条目的副本
 func PopTraceContext() bool {
-	data := GetProfLabel()
-	sizeStr, _ := data[GRTTraceContextLen]
-	size, err := strconv.Atoi(sizeStr)
-	if err != nil {
-		return false
-	}
-	if size == 0 {
+	data := peekProfLabel()
+	size, err := strconv.Atoi(data[GRTTraceContextLen])
+	if err != nil || size == 0 {
 		return false
 	}
 	newSize := size - 1
-	removeKey := getTargetKey(newSize)
-	newSizeStr := strconv.Itoa(newSize)
-	delete(data, removeKey)
-	data[GRTTraceContextLen] = newSizeStr
+	if f := stackFrameAt(data, newSize); f != nil && f.labels == Runtime_getProfLabel() {
+		runtime_setProfLabel(f.prev)
+		return true
+	}
+	data = cloneProfLabel(0)
+	delete(data, getTargetKey(newSize))
+	delete(data, stackFrameKey(newSize))
+	data[GRTTraceContextLen] = strconv.Itoa(newSize)
+	restoreSpanLabels(data, newSize)
+	setProfLabel(data)
 	return true
 }
 
+// TraceStackDepth 返回当前 goroutine trace 栈的深度
+func TraceStackDepth() int {
+	data := peekProfLabel()
+	size, _ := strconv.Atoi(data[GRTTraceContextLen])
+	return size
+}
+
+// PopTraceContextTo 将当前 goroutine 的 trace 栈弹出到 depth，返回弹出的条目数
+func PopTraceContextTo(depth int) int {
+	popped := 0
+	for TraceStackDepth() > depth && PopTraceContext() {
+		popped++
+	}
+	return popped
+}
+
+// 出栈不匹配的原因，作为 TraceStackMismatch 的 reason 属性
+const (
+	MismatchLeaked    = "leaked"    // 弹出 span 时发现其上方还有未弹出的条目
+	MismatchMissing   = "missing"   // span 的条目已被提前弹出
+	MismatchUnderflow = "underflow" // 对空栈出栈
+	MismatchUnmatched = "unmatched" // EndSpan 找不到对应的 StartSpan
+)
+
+// probe 自身的统计只做原子加，由异步指标在采集时读取，压栈/出栈路径上不调用指标 API
+var stackMismatches = map[string]*atomic.Int64{
+	MismatchLeaked:    new(atomic.Int64),
+	MismatchMissing:   new(atomic.Int64),
+	MismatchUnderflow: new(atomic.Int64),
+	MismatchUnmatched: new(atomic.Int64),
+}
+
+// 超过该深度的压栈计入同一个桶
+const maxTrackedStackDepth = 16
+
+// 按压栈后的深度计数，只在注册了运行时指标时统计
+var stackDepthStatsEnabled atomic.Bool
+var stackDepthCounts [maxTrackedStackDepth + 1]atomic.Int64
+
+func recordStackMismatch(reason string, n int) {
+	stackMismatches[reason].Add(int64(n))
+}
+
+// 将一个 span 的条目弹出，depth 为压栈前的深度，正常情况下恰好弹出 1 条
+func popSpanEntry(depth int) {
+	switch n := PopTraceContextTo(depth); {
+	case n == 0:
+		recordStackMismatch(MismatchMissing, 1)
+	case n > 1:
+		recordStackMismatch(MismatchLeaked, n-1)
+	}
+}
+
+// OnSpanStart 把 span 压入当前 goroutine 的 trace 栈
 func OnSpanStart(span trace.Span) {
-	data := GetProfLabel()
-	sizeStr, _ := data[GRTTraceContextLen]
-	size, _ := strconv.Atoi(sizeStr)
-	addKey := getTargetKey(size)
+	pushSpan(span, &stackFrame{})
+}
+
+// 在复制出的 label map 上压栈后整体换上，f 记录这次压栈
+func pushSpan(span trace.Span, f *stackFrame) {
+	copy(f.tag[:], stackFrameTag)
+	f.span = span
+	f.prev = Runtime_getProfLabel()
+	data := cloneProfLabel(4)
+	size, _ := strconv.Atoi(data[GRTTraceContextLen])
 	newSize := size + 1
 	data[GRTTraceContextLen] = strconv.Itoa(newSize)
-	data[addKey] = EncodeTraceContext(span.SpanContext())
+	data[getTargetKey(size)] = EncodeTraceContext(span.SpanContext())
+	data[stackFrameKey(size)] = f.ref()
+	setSpanLabels(data, span.SpanContext(), span)
+	f.labels = setProfLabel(data)
+	if stackDepthStatsEnabled.Load() {
+		stackDepthCounts[min(newSize, maxTrackedStackDepth)].Add(1)
+	}
 }
 
 func OnSpanEnd(span trace.Span) bool {
-	return PopTraceContext()
+	if !PopTraceContext() {
+		recordStackMismatch(MismatchUnderflow, 1)
+		return false
+	}
+	return true
 }
 
 func RetrieveSpanContext(ctx context.Context) (context.Context, error) {
-	data := GetProfLabel()
+	data := peekProfLabel()
 	sizeStr, _ := data[GRTTraceContextLen]
 	size, _ := strconv.Atoi(sizeStr)
 	if size == 0 {
@@ -117,7 +214,39 @@ func RetrieveSpanContext(ctx context.Context) (context.Context, error) {
 	}
 	targetKey := getTargetKey(size - 1)
 	tcStr, _ := data[targetKey]
-	return trace.ContextWithSpanContext(ctx, DecodeTraceContext(tcStr)), nil
+	sc, err := ParseTraceContext(tcStr)
+	if err != nil {
+		return ctx, err
+	}
+	return trace.ContextWithSpanContext(ctx, sc), nil
+}
+
+// ActiveSpan 返回当前 goroutine trace 栈顶的 span。栈顶 span 不是由 OnSpanStart 压入的
+// 可记录 span 时，返回只携带 SpanContext 的非记录 span；栈为空时返回的 span 无效
+func ActiveSpan() trace.Span {
+	ctx, err := RetrieveSpanContext(context.Background())
+	if err != nil {
+		return trace.SpanFromContext(ctx)
+	}
+	if span, ok := stackSpan(peekProfLabel(), TraceStackDepth()-1); ok && span.IsRecording() {
+		return span
+	}
+	return trace.SpanFromContext(ctx)
+}
+
+// EnsureSpanContext 当 ctx 中没有有效的 span 时，使用当前 goroutine trace 栈顶的 span 作为父 span，
+// trace 栈为空时使用父进程通过 TRACEPARENT 传入的 span
+func EnsureSpanContext(ctx context.Context) context.Context {
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+	if nctx, err := RetrieveSpanContext(ctx); err == nil {
+		return nctx
+	}
+	if envParent.IsValid() {
+		return trace.ContextWithRemoteSpanContext(ctx, envParent)
+	}
+	return ctx
 }
 
 //type GlobalTraceContext struct {
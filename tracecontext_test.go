@@ -5,7 +5,13 @@ import (
 	"go.opentelemetry.io/otel"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.opentelemetry.io/otel/trace"
+	"io"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func GetSpanContext() (trace.SpanContext, error) {
@@ -190,3 +196,121 @@ func BenchmarkDirectNestedSpans(b *testing.B) {
 		directEndSpan(root) // 结束根Span
 	}
 }
+
+// 同一个 span 压入另一个 goroutine 的 trace 栈并在那里弹出，不影响原 goroutine 找回它
+func TestActiveSpanSharedAcrossGoroutines(t *testing.T) {
+	newSpanRecorder(t)
+	_, span := StartSpan(context.Background(), "owner")
+	defer EndSpan(nil)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		IsolateProfLabel()
+		depth := TraceStackDepth()
+		OnSpanStart(span)
+		if ActiveSpan() != span {
+			t.Errorf("expect span active on helper goroutine")
+		}
+		PopTraceContextTo(depth)
+	}()
+	<-done
+
+	if ActiveSpan() != span {
+		t.Errorf("expect span still active on owner goroutine after helper popped it")
+	}
+}
+
+// 栈上的编码被破坏时返回错误而不是 panic
+func TestRetrieveCorruptedTraceContext(t *testing.T) {
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled})
+
+	IsolateProfLabel()
+	OnSpanStart(trace.SpanFromContext(trace.ContextWithSpanContext(context.Background(), sc)))
+	defer PopTraceContext()
+	data := cloneProfLabel(0)
+	data[getTargetKey(TraceStackDepth()-1)] = ""
+	setProfLabel(data)
+	if _, err := RetrieveSpanContext(context.Background()); err == nil {
+		t.Errorf("expect error for corrupted stack entry")
+	}
+}
+
+// 压栈和出栈不修改已装上的 map，其他 goroutine 同时遍历 label 不会与之竞争，go test -race 下验证
+func TestTraceStackConcurrentProfile(t *testing.T) {
+	newSpanRecorder(t)
+	enableProfilingLabels(t, ProfilingLabelsNames)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				pprof.Lookup("goroutine").WriteTo(io.Discard, 1)
+			}
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		WithSpan("outer", func(ctx context.Context) error {
+			return WithSpan("inner", func(ctx context.Context) error { return nil })
+		})
+	}
+	close(stop)
+	wg.Wait()
+}
+
+// 子 goroutine 继承的 map 不受父 goroutine 之后压栈和出栈的影响
+func TestTraceStackDoesNotMutateInheritedLabels(t *testing.T) {
+	newSpanRecorder(t)
+	IsolateProfLabel()
+	_, outer := StartSpan(context.Background(), "outer")
+	defer EndSpan(nil)
+
+	inherited := make(chan map[string]string)
+	release := make(chan struct{})
+	go func() {
+		inherited <- GetProfLabel()
+		<-release
+	}()
+	data := <-inherited
+	depth := data[GRTTraceContextLen]
+	WithSpan("inner", func(ctx context.Context) error { return nil })
+	close(release)
+	if data[GRTTraceContextLen] != depth || ActiveSpan() != outer {
+		t.Errorf("expect inherited labels unchanged, got %v", data)
+	}
+}
+
+// goroutine 不出栈就退出时，压入的 span 随它的 label 一起回收，不会留在全局表里
+func TestTraceStackFreedWithGoroutine(t *testing.T) {
+	// SpanRecorder 会持有开启的 span，这里用不带 processor 的 provider
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider())
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	const n = 100
+	var freed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			IsolateProfLabel()
+			_, span := StartSpan(context.Background(), "leaked")
+			runtime.SetFinalizer(span, func(trace.Span) { freed.Add(1) })
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < 100 && freed.Load() < n; i++ {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := freed.Load(); got != n {
+		t.Errorf("expect %d spans freed with their goroutines, got %d", n, got)
+	}
+}
@@ -0,0 +1,84 @@
+package probesdk
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type transport struct {
+	base http.RoundTripper
+}
+
+// Transport 为 base 添加客户端埋点，base 为 nil 时使用 http.DefaultTransport。
+// 请求的 context 中没有 span 时，以当前 goroutine trace 栈顶的 span 为父 span，
+// 并将 traceparent/tracestate/baggage 注入请求头
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{base: base}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	ctx, span := otel.Tracer(TracerName).Start(
+		EnsureSpanContext(req.Context()),
+		req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(httpClientAttributes(req)...),
+	)
+	defer span.End()
+
+	// RoundTripper 不允许修改传入的请求
+	req = req.Clone(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := t.base.RoundTrip(req)
+	status := 0
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		status = resp.StatusCode
+		span.SetAttributes(semconv.HTTPStatusCode(status))
+		if status >= http.StatusBadRequest {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+
+	ClientRequestDuration.Record(ctx, time.Since(start).Seconds(), httpClientMetricAttributes(req, status)...)
+	return resp, err
+}
+
+func httpClientAttributes(req *http.Request) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		semconv.HTTPMethod(req.Method),
+		semconv.URLFull(req.URL.Redacted()),
+		semconv.ServerAddress(req.URL.Hostname()),
+	}
+	if port, err := strconv.Atoi(req.URL.Port()); err == nil {
+		attrs = append(attrs, semconv.ServerPort(port))
+	}
+	return attrs
+}
+
+func httpClientMetricAttributes(req *http.Request, status int) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		semconv.HTTPRequestMethodKey.String(req.Method),
+		semconv.ServerAddress(req.URL.Hostname()),
+	}
+	if port, err := strconv.Atoi(req.URL.Port()); err == nil {
+		attrs = append(attrs, semconv.ServerPort(port))
+	}
+	if status != 0 {
+		attrs = append(attrs, semconv.HTTPResponseStatusCode(status))
+	}
+	return attrs
+}
@@ -0,0 +1,105 @@
+package probesdk
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// 返回一个记录收到的请求头的测试服务器
+func newHeaderServer(t *testing.T) (*httptest.Server, *http.Header) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &got
+}
+
+func remoteSpanContext(header http.Header) trace.SpanContext {
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier(header))
+	return trace.SpanContextFromContext(ctx)
+}
+
+func TestTransportFromGoroutineStack(t *testing.T) {
+	sr := newSpanRecorder(t)
+	reader := newMetricReader(t)
+	srv, got := newHeaderServer(t)
+	client := &http.Client{Transport: Transport(nil)}
+
+	err := WithSpan("legacy", func(_ context.Context) error {
+		// 老代码没有传递 ctx
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parent := findSpan(t, sr, "legacy")
+	clientSpan := findSpan(t, sr, "GET")
+	if clientSpan.SpanKind() != trace.SpanKindClient {
+		t.Errorf("expect client span, got %v", clientSpan.SpanKind())
+	}
+	if clientSpan.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("expect client span parented from goroutine stack")
+	}
+	remote := remoteSpanContext(*got)
+	if remote.TraceID() != parent.SpanContext().TraceID() || remote.SpanID() != clientSpan.SpanContext().SpanID() {
+		t.Errorf("expect traceparent carrying client span, got %v", got.Get("traceparent"))
+	}
+
+	duration := collectMetric(t, reader, "http.client.request.duration").Data.(metricdata.Histogram[float64])
+	if len(duration.DataPoints) != 1 || duration.DataPoints[0].Count != 1 {
+		t.Errorf("expect one client request recorded, got %+v", duration.DataPoints)
+	}
+}
+
+func TestTransportPrefersRequestContext(t *testing.T) {
+	sr := newSpanRecorder(t)
+	newMetricReader(t)
+	srv, got := newHeaderServer(t)
+	client := &http.Client{Transport: Transport(http.DefaultTransport)}
+
+	ctx, explicit := otel.Tracer(TracerName).Start(context.Background(), "explicit")
+	member, _ := baggage.NewMember("tenant", "acme")
+	bag, _ := baggage.New(member)
+	ctx = baggage.ContextWithBaggage(ctx, bag)
+
+	err := WithSpan("stack", func(_ context.Context) error {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		if req.Header.Get("traceparent") != "" {
+			t.Errorf("original request must not be modified")
+		}
+		return resp.Body.Close()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	explicit.End()
+
+	clientSpan := findSpan(t, sr, "POST")
+	if clientSpan.Parent().SpanID() != explicit.SpanContext().SpanID() {
+		t.Errorf("expect request context span as parent")
+	}
+	if remoteSpanContext(*got).SpanID() != clientSpan.SpanContext().SpanID() {
+		t.Errorf("expect traceparent carrying client span, got %v", got.Get("traceparent"))
+	}
+	if got.Get("baggage") != "tenant=acme" {
+		t.Errorf("expect baggage header, got %q", got.Get("baggage"))
+	}
+}
@@ -0,0 +1,162 @@
+package probesdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	metric2 "go.opentelemetry.io/otel/sdk/metric"
+	"io"
+	"os"
+	"strings"
+)
+
+// METRIC_VIEWS_ENV 指向 JSON 格式的 view 配置文件，内容为 ViewConfig 数组
+const METRIC_VIEWS_ENV = "PROBESDK_METRIC_VIEWS"
+
+// ViewConfig 描述如何改写一个或一组指标的输出，Instrument 支持 * 和 ? 通配
+type ViewConfig struct {
+	Instrument  string `json:"instrument"`
+	Rename      string `json:"rename,omitempty"`
+	Description string `json:"description,omitempty"`
+	Drop        bool   `json:"drop,omitempty"`
+	// Buckets 显式直方图的桶边界，与 Exponential 互斥
+	Buckets     []float64                   `json:"buckets,omitempty"`
+	Exponential *ExponentialHistogramConfig `json:"exponential,omitempty"`
+	// AllowAttributes 和 DenyAttributes 互斥
+	AllowAttributes []string `json:"allow_attributes,omitempty"`
+	DenyAttributes  []string `json:"deny_attributes,omitempty"`
+}
+
+// ExponentialHistogramConfig 以 2 为底的指数直方图参数，0 表示使用 SDK 默认值
+type ExponentialHistogramConfig struct {
+	MaxSize  int32 `json:"max_size,omitempty"`
+	MaxScale int32 `json:"max_scale,omitempty"`
+}
+
+// 内置指标的默认桶边界，覆盖亚毫秒到数十秒
+var DefaultViews = []ViewConfig{
+	{
+		Instrument: "http.server.request.duration",
+		Buckets:    []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	},
+	{
+		Instrument: "http.client.request.duration",
+		Buckets:    []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	},
+	{
+		Instrument: "rpc.*.duration",
+		Buckets:    []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000},
+	},
+}
+
+// View 将配置转换为 SDK 的 View
+func (c ViewConfig) View() (metric2.View, error) {
+	if c.Instrument == "" {
+		return nil, fmt.Errorf("view: instrument is required")
+	}
+	if c.Rename != "" && strings.ContainsAny(c.Instrument, "*?") {
+		return nil, fmt.Errorf("view %q: cannot rename instruments matched by wildcard", c.Instrument)
+	}
+	if len(c.Buckets) > 0 && c.Exponential != nil {
+		return nil, fmt.Errorf("view %q: buckets and exponential are mutually exclusive", c.Instrument)
+	}
+	if len(c.AllowAttributes) > 0 && len(c.DenyAttributes) > 0 {
+		return nil, fmt.Errorf("view %q: allow_attributes and deny_attributes are mutually exclusive", c.Instrument)
+	}
+	for i := 1; i < len(c.Buckets); i++ {
+		if c.Buckets[i] <= c.Buckets[i-1] {
+			return nil, fmt.Errorf("view %q: buckets must be strictly increasing", c.Instrument)
+		}
+	}
+
+	stream := metric2.Stream{Name: c.Rename, Description: c.Description}
+	switch {
+	case c.Drop:
+		stream.Aggregation = metric2.AggregationDrop{}
+	case len(c.Buckets) > 0:
+		stream.Aggregation = metric2.AggregationExplicitBucketHistogram{Boundaries: c.Buckets}
+	case c.Exponential != nil:
+		agg := metric2.AggregationBase2ExponentialHistogram{MaxSize: 160, MaxScale: 20}
+		if c.Exponential.MaxSize > 0 {
+			agg.MaxSize = c.Exponential.MaxSize
+		}
+		if c.Exponential.MaxScale > 0 {
+			agg.MaxScale = c.Exponential.MaxScale
+		}
+		stream.Aggregation = agg
+	}
+	switch {
+	case len(c.AllowAttributes) > 0:
+		stream.AttributeFilter = attribute.NewAllowKeysFilter(toAttributeKeys(c.AllowAttributes)...)
+	case len(c.DenyAttributes) > 0:
+		stream.AttributeFilter = attribute.NewDenyKeysFilter(toAttributeKeys(c.DenyAttributes)...)
+	}
+	return metric2.NewView(metric2.Instrument{Name: c.Instrument}, stream), nil
+}
+
+// NewViews 将 configs 与 DefaultViews 合并后转换为 SDK 的 View。一个指标只要被 configs 中任一 view 匹配（包括通配），
+// 就不再使用默认配置，否则同一个指标会产生两个流
+func NewViews(configs []ViewConfig) ([]metric2.View, error) {
+	userViews := make([]metric2.View, 0, len(configs))
+	for _, c := range configs {
+		v, err := c.View()
+		if err != nil {
+			return nil, err
+		}
+		userViews = append(userViews, v)
+	}
+
+	views := append(make([]metric2.View, 0, len(userViews)+len(DefaultViews)), userViews...)
+	for _, c := range DefaultViews {
+		v, err := c.View()
+		if err != nil {
+			return nil, err
+		}
+		views = append(views, func(inst metric2.Instrument) (metric2.Stream, bool) {
+			for _, u := range userViews {
+				if _, ok := u(inst); ok {
+					return metric2.Stream{}, false
+				}
+			}
+			return v(inst)
+		})
+	}
+	return views, nil
+}
+
+// LoadViewConfigs 从 JSON 中读取 ViewConfig 数组
+func LoadViewConfigs(r io.Reader) ([]ViewConfig, error) {
+	var configs []ViewConfig
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&configs); err != nil {
+		return nil, fmt.Errorf("failed to decode view configs: %w", err)
+	}
+	return configs, nil
+}
+
+// 读取 METRIC_VIEWS_ENV 指定的配置文件，未设置时只使用 DefaultViews
+func loadViewsFromEnv() ([]metric2.View, error) {
+	path := os.Getenv(METRIC_VIEWS_ENV)
+	if path == "" {
+		return NewViews(nil)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	configs, err := LoadViewConfigs(f)
+	if err != nil {
+		return nil, err
+	}
+	return NewViews(configs)
+}
+
+func toAttributeKeys(keys []string) []attribute.Key {
+	result := make([]attribute.Key, len(keys))
+	for i, k := range keys {
+		result[i] = attribute.Key(k)
+	}
+	return result
+}
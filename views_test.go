@@ -0,0 +1,140 @@
+package probesdk
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	metric2 "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"strings"
+	"testing"
+)
+
+const testViewConfig = `[
+	{"instrument": "rpc.fast", "buckets": [0.0001, 0.0005, 0.001]},
+	{"instrument": "batch.duration", "exponential": {"max_size": 80}},
+	{"instrument": "legacy.count", "rename": "orders.count", "deny_attributes": ["user.id"]},
+	{"instrument": "debug.*", "drop": true},
+	{"instrument": "queue.depth", "allow_attributes": ["queue"]}
+]`
+
+func newViewRegistry(t *testing.T, configs []ViewConfig) (*MetricRegistry, *metric2.ManualReader) {
+	views, err := NewViews(configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := metric2.NewManualReader()
+	provider := metric2.NewMeterProvider(metric2.WithReader(reader), metric2.WithView(views...))
+	return NewMetricRegistry(provider.Meter("test")), reader
+}
+
+func TestViewsFromConfig(t *testing.T) {
+	configs, err := LoadViewConfigs(strings.NewReader(testViewConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, reader := newViewRegistry(t, configs)
+	ctx := context.Background()
+
+	fast, _ := r.Histogram(InstrumentSpec{Name: "rpc.fast", Unit: "s"})
+	fast.Record(ctx, 0.0003)
+	batch, _ := r.Histogram(InstrumentSpec{Name: "batch.duration", Unit: "s"})
+	batch.Record(ctx, 12.5)
+	legacy, _ := r.Counter(InstrumentSpec{Name: "legacy.count"})
+	legacy.Add(ctx, 1, attribute.String("user.id", "42"), attribute.String("region", "bj"))
+	debug, _ := r.Counter(InstrumentSpec{Name: "debug.calls"})
+	debug.Add(ctx, 1)
+	depth, _ := r.Gauge(InstrumentSpec{Name: "queue.depth"})
+	depth.Record(ctx, 7, attribute.String("queue", "orders"), attribute.String("host", "a"))
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(ctx, &rm); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]metricdata.Metrics{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			got[m.Name] = m
+		}
+	}
+
+	bounds := got["rpc.fast"].Data.(metricdata.Histogram[float64]).DataPoints[0].Bounds
+	if len(bounds) != 3 || bounds[0] != 0.0001 {
+		t.Errorf("expect explicit buckets, got %v", bounds)
+	}
+	if _, ok := got["batch.duration"].Data.(metricdata.ExponentialHistogram[float64]); !ok {
+		t.Errorf("expect exponential histogram, got %T", got["batch.duration"].Data)
+	}
+	if _, ok := got["legacy.count"]; ok {
+		t.Errorf("expect legacy.count renamed")
+	}
+	renamed := got["orders.count"].Data.(metricdata.Sum[int64]).DataPoints[0].Attributes
+	if renamed.HasValue("user.id") || !renamed.HasValue("region") {
+		t.Errorf("expect user.id denied, got %v", renamed.ToSlice())
+	}
+	if _, ok := got["debug.calls"]; ok {
+		t.Errorf("expect debug.calls dropped")
+	}
+	gaugeAttrs := got["queue.depth"].Data.(metricdata.Gauge[float64]).DataPoints[0].Attributes
+	if gaugeAttrs.Len() != 1 || !gaugeAttrs.HasValue("queue") {
+		t.Errorf("expect only queue attribute, got %v", gaugeAttrs.ToSlice())
+	}
+}
+
+func TestDefaultViews(t *testing.T) {
+	r, reader := newViewRegistry(t, nil)
+	h, _ := r.Histogram(InstrumentSpec{Name: "http.server.request.duration", Unit: "s"})
+	h.Record(context.Background(), 0.0002)
+
+	bounds := collectMetric(t, reader, "http.server.request.duration").Data.(metricdata.Histogram[float64]).DataPoints[0].Bounds
+	if bounds[0] != 0.0005 {
+		t.Errorf("expect sub-millisecond default bucket, got %v", bounds)
+	}
+}
+
+// 通配 view 匹配到有默认配置的指标时，只产生用户 view 的一个流
+func TestWildcardViewOverridesDefault(t *testing.T) {
+	r, reader := newViewRegistry(t, []ViewConfig{{Instrument: "http.*", Buckets: []float64{1, 2}}})
+	h, _ := r.Histogram(InstrumentSpec{Name: "http.server.request.duration", Unit: "s"})
+	h.Record(context.Background(), 0.0002)
+	rpc, _ := r.Histogram(InstrumentSpec{Name: "rpc.server.duration", Unit: "ms"})
+	rpc.Record(context.Background(), 3)
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	streams := map[string]int{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			streams[m.Name]++
+		}
+	}
+	if streams["http.server.request.duration"] != 1 {
+		t.Fatalf("expect a single stream, got %d", streams["http.server.request.duration"])
+	}
+	bounds := collectMetric(t, reader, "http.server.request.duration").Data.(metricdata.Histogram[float64]).DataPoints[0].Bounds
+	if len(bounds) != 2 || bounds[0] != 1 {
+		t.Errorf("expect user buckets, got %v", bounds)
+	}
+	if bounds := collectMetric(t, reader, "rpc.server.duration").Data.(metricdata.Histogram[float64]).DataPoints[0].Bounds; bounds[0] != 0.1 {
+		t.Errorf("expect default buckets kept for unmatched instrument, got %v", bounds)
+	}
+}
+
+func TestInvalidViewConfigs(t *testing.T) {
+	invalid := []ViewConfig{
+		{},
+		{Instrument: "a.*", Rename: "b"},
+		{Instrument: "a", Buckets: []float64{1}, Exponential: &ExponentialHistogramConfig{}},
+		{Instrument: "a", AllowAttributes: []string{"x"}, DenyAttributes: []string{"y"}},
+		{Instrument: "a", Buckets: []float64{2, 1}},
+	}
+	for _, c := range invalid {
+		if _, err := c.View(); err == nil {
+			t.Errorf("expect error for %+v", c)
+		}
+	}
+	if _, err := LoadViewConfigs(strings.NewReader(`[{"instrument": "a", "bucket": [1]}]`)); err == nil {
+		t.Errorf("expect error for unknown field")
+	}
+}